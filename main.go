@@ -1,7 +1,147 @@
 package main
 
-import "github.com/cockroachdb/cockroach/pkg/cmd/sqlsmith/sqlsmith"
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/sqlsmith/sqlsmith"
+)
+
+var (
+	flagAllowCodes    = flag.String("allow-codes", "", "comma-separated SQLSTATE codes of errors which are expected")
+	flagAllowErrors   = flag.String("allow-errors", "", "regular expression matching messages of errors which are expected")
+	flagStatsInterval = flag.Duration("stats-interval", 0, "how often to print statistics about the run")
+	flagDuration      = flag.Duration("duration", 0, "how long to run for; until interrupted if zero")
+
+	flagDatabases = flag.String("databases", "", "comma-separated databases to load tables from; defaults to the current database")
+	flagSchemas   = flag.String("schemas", "public", "comma-separated schemas to load tables from, including virtual schemas like pg_catalog")
+
+	flagProfile     = flag.String("profile", "", "preset production weights, one of: "+strings.Join(sqlsmith.Profiles(), ", "))
+	flagWeightsFile = flag.String("weights-file", "", "JSON file of production weights, applied after -profile")
+	flagWeights     = flag.String("weights", "", "comma-separated name=weight production weights, applied after -weights-file")
+	flagSize        = flag.String("size", "default", "preset budget bounding the size of statements, one of: "+strings.Join(sqlsmith.BudgetProfiles(), ", "))
+	flagBudget      = flag.String("budget", "", "comma-separated name=limit overrides of -size, for depth, nodes, joins, subqueries and length; 0 is unbounded")
+
+	flagWorkers  = flag.Int("workers", 1, "number of concurrent workers")
+	flagSeed     = flag.Int64("seed", 0, "seed for the run; chosen based on the current time if zero")
+	flagLogDir   = flag.String("log-dir", "", "directory in which each worker writes its own log, instead of stdout")
+	flagPopulate = flag.Int("populate", 0, "number of rows to insert into each table, including the existing tables of the database, before querying it")
+
+	flagSnapshot   = flag.String("snapshot", "", "schema snapshot (JSON, or .sql with this binary's builtin operators and functions) to generate statements against without a database")
+	flagGenerate   = flag.Int("generate", 100, "number of statements to generate with -snapshot")
+	flagOut        = flag.String("out", "", "file to write statements generated with -snapshot to, instead of stdout")
+	flagDumpSchema = flag.String("dump-schema", "", "write a snapshot of the live database's schema to this file and exit")
+
+	flagJSONOut           = flag.String("json-out", "", "file to write a line of JSON to for every statement executed")
+	flagCorpusOut         = flag.String("corpus-out", "", "file to write every successfully executed statement to")
+	flagLogicTestOut      = flag.String("logictest-out", "", "file to write executed statements to as a logic test")
+	flagCoverageProfile   = flag.String("coverage-profile", "", "coverage profile the server keeps up to date, to guide the run by")
+	flagCoverageCorpusOut = flag.String("coverage-corpus-out", "", "file to write statements which reached new coverage to")
+	flagMutate            = flag.String("mutate", "", "comma-separated .sql or logic test files whose statements are mutated and executed alongside generated ones")
+
+	flagStatementTimeout = flag.Duration("statement-timeout", time.Minute, "how long a statement may run before it's considered hung")
+	flagKeepGoing        = flag.Bool("keep-going", false, "keep going after the server crashes, once it has come back")
+	flagRestartCmd       = flag.String("restart-cmd", "", "shell command used to restart the server after a crash")
+	flagReconnectTimeout = flag.Duration("reconnect-timeout", time.Minute, "how long to wait for the server to come back after a crash")
+)
 
 func main() {
-	sqlsmith.Run()
+	flag.Parse()
+
+	var opts sqlsmith.Options
+	if *flagAllowCodes != "" {
+		opts.AllowCodes = strings.Split(*flagAllowCodes, ",")
+	}
+	if *flagAllowErrors != "" {
+		re, err := regexp.Compile(*flagAllowErrors)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -allow-errors: %v\n", err)
+			os.Exit(2)
+		}
+		opts.AllowErrors = []*regexp.Regexp{re}
+	}
+	opts.StatsInterval = *flagStatsInterval
+	opts.Duration = *flagDuration
+	if *flagDatabases != "" {
+		opts.Databases = strings.Split(*flagDatabases, ",")
+	}
+	if *flagSchemas != "" {
+		opts.Schemas = strings.Split(*flagSchemas, ",")
+	}
+	weights, err := sqlsmith.MakeWeights(*flagProfile, *flagWeightsFile, *flagWeights)
+	if err != nil {
+		fatal(err)
+	}
+	opts.Weights = weights
+	budget, err := sqlsmith.MakeBudget(*flagSize, *flagBudget)
+	if err != nil {
+		fatal(err)
+	}
+	opts.Budget = &budget
+	opts.Workers = *flagWorkers
+	opts.Seed = *flagSeed
+	opts.LogDir = *flagLogDir
+	opts.PopulateRows = *flagPopulate
+	opts.JSONFile = *flagJSONOut
+	opts.CorpusFile = *flagCorpusOut
+	opts.LogicTestFile = *flagLogicTestOut
+	if *flagCoverageProfile != "" {
+		opts.CoverageCollector = sqlsmith.FileCoverageCollector{Path: *flagCoverageProfile}
+		opts.CoverageCorpusFile = *flagCoverageCorpusOut
+	}
+	if *flagMutate != "" {
+		opts.MutateFiles = strings.Split(*flagMutate, ",")
+	}
+	opts.StatementTimeout = *flagStatementTimeout
+	opts.KeepGoing = *flagKeepGoing
+	opts.ReconnectTimeout = *flagReconnectTimeout
+	if *flagRestartCmd != "" {
+		opts.RestartHook = func() error {
+			cmd := exec.Command("sh", "-c", *flagRestartCmd)
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+			return cmd.Run()
+		}
+	}
+
+	opts.SnapshotFile = *flagSnapshot
+
+	switch {
+	case *flagDumpSchema != "":
+		f, err := os.Create(*flagDumpSchema)
+		if err != nil {
+			fatal(err)
+		}
+		if err := sqlsmith.DumpSchema(opts, f); err != nil {
+			fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			fatal(err)
+		}
+	case *flagSnapshot != "":
+		out := os.Stdout
+		if *flagOut != "" {
+			f, err := os.Create(*flagOut)
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := sqlsmith.Generate(opts, *flagGenerate, out); err != nil {
+			fatal(err)
+		}
+	default:
+		sqlsmith.Run(opts)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
 }
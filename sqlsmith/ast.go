@@ -0,0 +1,176 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	// Import builtins for pretty printing.
+	_ "github.com/cockroachdb/cockroach/pkg/sql/sem/builtins"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+)
+
+// Generated statements and expressions are converted to the parser's AST
+// before they're used, so everything sqlsmith-go outputs is known to be
+// syntactically valid, and downstream tools can work with the tree rather
+// than the text. Anything which fails to convert is a bug in the generator,
+// except for syntax the parser knows but doesn't implement, such as
+// materialized views: the server under test may be newer than the parser, so
+// such statements are executed as they are.
+
+// GeneratorBugError is returned when sqlsmith-go generates a statement or
+// expression which doesn't round trip through the parser: either it doesn't
+// parse, or its AST prints as something which parses differently.
+type GeneratorBugError struct {
+	// SQL is the generated text.
+	SQL string
+	Err error
+}
+
+func (e *GeneratorBugError) Error() string {
+	return fmt.Sprintf("generator bug: generated SQL doesn't round trip through the parser: %v\n%s", e.Err, e.SQL)
+}
+
+// toStatement converts a generated statement to an AST. If the statement uses
+// syntax the parser doesn't implement, the parser's error is returned as is.
+func toStatement(f Format) (tree.Statement, error) {
+	var buf bytes.Buffer
+	f.Format(&buf)
+	return parseStatement(buf.String())
+}
+
+// parseStatement parses sql, and checks that the AST prints as text which
+// parses back to the same AST.
+func parseStatement(sql string) (tree.Statement, error) {
+	stmt, err := parser.ParseOne(sql)
+	if isUnimplemented(err) {
+		return nil, err
+	} else if err != nil {
+		return nil, &GeneratorBugError{SQL: sql, Err: err}
+	}
+	printed := tree.AsString(stmt.AST)
+	reparsed, err := parser.ParseOne(printed)
+	if err != nil {
+		return nil, &GeneratorBugError{SQL: sql, Err: fmt.Errorf("printed as %s: %v", printed, err)}
+	}
+	if tree.AsString(reparsed.AST) != printed {
+		return nil, &GeneratorBugError{SQL: sql, Err: fmt.Errorf("printed as %s, which parses differently", printed)}
+	}
+	return stmt.AST, nil
+}
+
+// prettyPrint pretty prints stmt. The pretty printer drops parentheses it
+// shouldn't, like those around a subtraction on the right of an addition, so
+// if its text parses to a different AST, stmt is printed on one line instead.
+func prettyPrint(stmt tree.Statement) string {
+	printed := tree.AsString(stmt)
+	pretty := tree.Pretty(stmt)
+	if sameAST(printed, pretty) {
+		return pretty
+	}
+	return printed
+}
+
+// sameAST returns whether the statements a and b parse to the same AST, but
+// for parentheses, which the pretty printer drops where they're redundant.
+func sameAST(a, b string) bool {
+	var printed [2]string
+	for i, sql := range []string{a, b} {
+		stmt, err := parser.ParseOne(sql)
+		if err != nil {
+			return false
+		}
+		stripParens(reflect.ValueOf(&stmt.AST).Elem())
+		printed[i] = tree.AsString(stmt.AST)
+	}
+	return printed[0] == printed[1]
+}
+
+var parenExprType = reflect.TypeOf((*tree.ParenExpr)(nil))
+
+// stripParens replaces every parenthesized expression reachable from v with
+// the expression inside the parentheses.
+func stripParens(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			stripParens(v.Elem())
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if v.CanSet() {
+			for v.Elem().Type() == parenExprType && !v.Elem().IsNil() {
+				inner := v.Elem().Elem().FieldByName("Expr")
+				if inner.IsNil() || !inner.Elem().Type().AssignableTo(v.Type()) {
+					break
+				}
+				v.Set(inner.Elem())
+			}
+		}
+		stripParens(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			stripParens(v.Field(i))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			stripParens(v.Index(i))
+		}
+	}
+}
+
+// isUnimplemented returns whether err is the parser rejecting syntax it knows
+// but doesn't implement.
+func isUnimplemented(err error) bool {
+	pgErr, ok := pgerror.GetPGCause(err)
+	return ok && pgErr.Code == pgerror.CodeFeatureNotSupportedError
+}
+
+// toExpr converts a generated scalar expression to an AST.
+func toExpr(e scalarExpr) (tree.Expr, error) {
+	var buf bytes.Buffer
+	e.Format(&buf)
+	expr, err := parser.ParseExpr(buf.String())
+	if err != nil {
+		return nil, &GeneratorBugError{SQL: buf.String(), Err: err}
+	}
+	printed := tree.AsString(expr)
+	reparsed, err := parser.ParseExpr(printed)
+	if err != nil {
+		return nil, &GeneratorBugError{SQL: buf.String(), Err: fmt.Errorf("printed as %s: %v", printed, err)}
+	}
+	if tree.AsString(reparsed) != printed {
+		return nil, &GeneratorBugError{SQL: buf.String(), Err: fmt.Errorf("printed as %s, which parses differently", printed)}
+	}
+	return expr, nil
+}
+
+// format returns the text of a generated statement, pretty printed from its
+// AST if it has one.
+func format(f Format) (string, error) {
+	stmt, err := toStatement(f)
+	if isUnimplemented(err) {
+		var buf bytes.Buffer
+		f.Format(&buf)
+		return buf.String(), nil
+	} else if err != nil {
+		return "", err
+	}
+	return prettyPrint(stmt), nil
+}
+
+// pretty pretty prints sql, which wasn't generated by sqlsmith-go but must
+// round trip through the parser all the same.
+func pretty(sql string) (string, error) {
+	stmt, err := parseStatement(sql)
+	if isUnimplemented(err) {
+		return sql, nil
+	} else if err != nil {
+		return "", err
+	}
+	return prettyPrint(stmt), nil
+}
@@ -0,0 +1,33 @@
+package sqlsmith
+
+import "testing"
+
+func TestPretty(t *testing.T) {
+	for _, tc := range []struct {
+		sql      string
+		expected string
+		bug      bool
+	}{
+		{sql: "select 1", expected: "SELECT 1"},
+		{sql: "create table t (a int primary key)", expected: "CREATE TABLE t (a INT8 PRIMARY KEY)"},
+		{sql: "create materialized view v as select 1", expected: "create materialized view v as select 1"},
+		{sql: "select from where", bug: true},
+		// The pretty printer drops redundant parentheses, but would drop
+		// these too.
+		{sql: "select (a - b) - c from t where (a = 1)", expected: "SELECT a - b - c FROM t WHERE a = 1"},
+		{sql: "select a + (b - c) from t", expected: "SELECT a + (b - c) FROM t"},
+	} {
+		result, err := pretty(tc.sql)
+		if tc.bug {
+			if _, ok := err.(*GeneratorBugError); !ok {
+				t.Errorf("%s: expected a generator bug, got %v", tc.sql, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.sql, err)
+		} else if result != tc.expected {
+			t.Errorf("%s: pretty printed as %s, expected %s", tc.sql, result, tc.expected)
+		}
+	}
+}
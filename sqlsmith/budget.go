@@ -0,0 +1,202 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Budget bounds the size of each generated statement. A limit of zero is
+// unbounded.
+type Budget struct {
+	// MaxDepth is how deeply scopes may nest.
+	MaxDepth int
+	// MaxNodes is how many scalar expressions and relations a statement may
+	// be made of. Once it's spent, only leaves are generated.
+	MaxNodes int
+	// MaxJoins is how many joins a statement may have.
+	MaxJoins int
+	// MaxSubqueryDepth is how deeply subqueries may nest.
+	MaxSubqueryDepth int
+	// MaxLength is the length of the longest statement, as text. Once about
+	// half of it has been generated, only leaves are generated, and once all
+	// of it has, nothing more is. Statements which still turn out longer are
+	// thrown away and generated again.
+	MaxLength int
+}
+
+// budgets are preset budgets.
+var budgets = map[string]Budget{
+	"small": {
+		MaxDepth:         4,
+		MaxNodes:         30,
+		MaxJoins:         1,
+		MaxSubqueryDepth: 1,
+		MaxLength:        1000,
+	},
+	"default": {
+		MaxDepth:         12,
+		MaxNodes:         500,
+		MaxJoins:         8,
+		MaxSubqueryDepth: 4,
+	},
+	"huge": {
+		MaxDepth:         30,
+		MaxNodes:         5000,
+		MaxJoins:         20,
+		MaxSubqueryDepth: 10,
+	},
+}
+
+// BudgetProfiles returns the names of the preset budgets.
+func BudgetProfiles() []string {
+	names := make([]string, 0, len(budgets))
+	for n := range budgets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MakeBudget returns the named preset budget, or the default one if profile
+// is empty, with overrides applied. overrides is a comma-separated list of
+// name=limit pairs, where the names are depth, nodes, joins, subqueries and
+// length.
+func MakeBudget(profile, overrides string) (Budget, error) {
+	if profile == "" {
+		profile = "default"
+	}
+	b, ok := budgets[profile]
+	if !ok {
+		return Budget{}, fmt.Errorf("unknown budget %q, expected one of %s",
+			profile, strings.Join(BudgetProfiles(), ", "))
+	}
+	if overrides == "" {
+		return b, nil
+	}
+	for _, o := range strings.Split(overrides, ",") {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 {
+			return Budget{}, fmt.Errorf("expected name=limit, found %q", o)
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || v < 0 {
+			return Budget{}, fmt.Errorf("invalid limit in %q", o)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "depth":
+			b.MaxDepth = v
+		case "nodes":
+			b.MaxNodes = v
+		case "joins":
+			b.MaxJoins = v
+		case "subqueries":
+			b.MaxSubqueryDepth = v
+		case "length":
+			b.MaxLength = v
+		default:
+			return Budget{}, fmt.Errorf("unknown limit %q", kv[0])
+		}
+	}
+	return b, nil
+}
+
+// spent is how much of the budget a statement has used so far. It is shared
+// by every scope of a statement, like namer.
+type spent struct {
+	nodes int
+	joins int
+	// length is roughly how long the statement's text is so far. Text which
+	// was generated and then discarded isn't counted.
+	length int
+}
+
+// nodeLength is roughly how much text a production adds around the
+// expressions and relations below it, such as keywords and punctuation.
+const nodeLength = 8
+
+// spend notes that a production was picked.
+func (s *scope) spend() {
+	s.spent.nodes++
+	s.spent.length += nodeLength
+}
+
+// spendLeaf notes the text of a leaf, such as a constant or a table name.
+func (s *scope) spendLeaf(leaf Format) {
+	if s.budget.MaxLength == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	leaf.Format(&buf)
+	s.spent.length += buf.Len()
+}
+
+func within(used, limit int) bool {
+	return limit == 0 || used < limit
+}
+
+// pushSubquery returns a scope for a subquery of s.
+func (s *scope) pushSubquery() *scope {
+	out := s.push()
+	out.subqueryDepth++
+	return out
+}
+
+// canRecurse returns whether the budget leaves room for a production with
+// further expressions or relations below it.
+func (s *scope) canRecurse() bool {
+	// Half the length budget is left for the leaves which finish the
+	// statement off.
+	return within(s.level+1, s.budget.MaxDepth) && within(s.spent.nodes, s.budget.MaxNodes) &&
+		within(2*s.spent.length, s.budget.MaxLength)
+}
+
+// overLength returns whether the statement is already longer than the
+// budget, so that any further expression or relation should be refused.
+func (s *scope) overLength() bool {
+	return s.budget.MaxLength > 0 && s.spent.length > s.budget.MaxLength
+}
+
+// deeper rolls for whether a production may recurse. Within the first lead
+// levels it always may, and below them the chance falls off evenly until the
+// depth budget is spent. Without a depth budget it falls off over six levels.
+func (s *scope) deeper(lead int) bool {
+	span := 6
+	if s.budget.MaxDepth > 0 {
+		span = s.budget.MaxDepth - lead
+	}
+	if span < 1 {
+		span = 1
+	}
+	return s.level < lead+1+s.rnd.Intn(span)
+}
+
+// canJoin returns whether the budget leaves room for another join.
+func (s *scope) canJoin() bool {
+	return within(s.spent.joins, s.budget.MaxJoins)
+}
+
+// canNest returns whether the budget leaves room for another level of
+// subquery.
+func (s *scope) canNest() bool {
+	return within(s.subqueryDepth, s.budget.MaxSubqueryDepth)
+}
+
+// fitsLength returns whether the text of expr is within the budget. The
+// length is only estimated while a statement is generated, so statements
+// which turn out too long are counted as failures of "budget.length".
+func (s *scope) fitsLength(expr relExpr) bool {
+	if s.budget.MaxLength == 0 {
+		return true
+	}
+	s.coverage.attempt("budget.length")
+	var buf bytes.Buffer
+	expr.Format(&buf)
+	if buf.Len() > s.budget.MaxLength {
+		s.coverage.fail("budget.length")
+		return false
+	}
+	return true
+}
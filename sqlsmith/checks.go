@@ -0,0 +1,317 @@
+package sqlsmith
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+)
+
+// Even when a statement succeeds, its result can be checked against what we
+// know of the statement we generated: it should have as many columns as the
+// statement asks for, no more rows than its LIMIT, no duplicate rows if it's
+// DISTINCT, and no NULLs in columns which can't be NULL. A result which
+// fails these checks is reported as a finding.
+
+// resultCheck checks the result of a statement as it's read.
+type resultCheck struct {
+	// cols is the number of columns the result should have, or -1 if it's
+	// unknown.
+	cols int
+	// limit is the most rows the result may have, or -1 if there is no limit.
+	limit int
+	// scalar is set if the statement is a scalar subquery.
+	scalar bool
+	// distinct is set if the result shouldn't have duplicate rows, in which
+	// case seen are the rows read so far.
+	distinct bool
+	seen     map[string]bool
+	// notNull are the columns of the result which may not be NULL.
+	notNull []int
+
+	rowCount   int
+	violations []string
+	// notes are results which look wrong, but may not be, such as a scalar
+	// subquery returning several rows on its own when the data may have
+	// changed since the statement using it was executed.
+	notes []string
+}
+
+// makeResultCheck returns the checks for the result of expr, which is a
+// scalar subquery if scalar is set, or nil if there's nothing to check.
+func makeResultCheck(expr relExpr, scalar bool) *resultCheck {
+	c := &resultCheck{cols: numCols(expr), limit: -1, scalar: scalar}
+	if sel, ok := expr.(*selectExpr); ok {
+		var limit int
+		if _, err := fmt.Sscanf(sel.limit, "limit %d", &limit); err == nil {
+			c.limit = limit
+		}
+		c.distinct = sel.distinct
+		c.notNull = notNullCols(sel)
+	}
+	if op, ok := expr.(*setOp); ok {
+		// Set operations without ALL remove duplicates.
+		c.distinct = !strings.HasSuffix(op.op, " all")
+	}
+	if c.cols < 0 && c.limit < 0 && !c.scalar && !c.distinct && len(c.notNull) == 0 {
+		return nil
+	}
+	if c.distinct {
+		c.seen = make(map[string]bool)
+	}
+	return c
+}
+
+// numCols returns the number of columns in the result of expr, or -1 if we
+// don't know.
+func numCols(expr relExpr) int {
+	switch e := expr.(type) {
+	case *selectExpr:
+		return len(e.selectList)
+	case *values:
+		if len(e.values) > 0 {
+			return len(e.values[0])
+		}
+	case *setOp:
+		return numCols(e.left)
+	case *insert:
+		return 0
+	}
+	return -1
+}
+
+// notNullCols returns the columns of sel which are references to columns
+// of tables which can't be NULL. Views are left out, since the nullability
+// of their columns isn't reliable, and so are outer joins, which we don't
+// generate.
+func notNullCols(sel *selectExpr) []int {
+	tables := make(map[string]*table)
+	for _, from := range sel.fromClause {
+		for _, t := range tableExprs(from) {
+			if !t.rel.isView {
+				tables[t.alias] = &t.rel
+			}
+		}
+	}
+	var result []int
+	for i, e := range sel.selectList {
+		ref, ok := e.(*colRefExpr)
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(ref.ref, ".", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		t, ok := tables[parts[0]]
+		if !ok {
+			continue
+		}
+		// Column references are quoted where they need to be.
+		for _, col := range t.cols {
+			if tree.NameString(col.name) == parts[1] && !col.nullable {
+				result = append(result, i)
+			}
+		}
+	}
+	return result
+}
+
+func (c *resultCheck) violate(format string, args ...interface{}) {
+	c.violations = append(c.violations, fmt.Sprintf(format, args...))
+}
+
+// columns checks the number of columns in the result.
+func (c *resultCheck) columns(n int) {
+	if c.cols >= 0 && n != c.cols {
+		c.violate("result has %d columns, expected %d", n, c.cols)
+	}
+}
+
+// row checks a row of the result.
+func (c *resultCheck) row(values []sql.NullString) {
+	c.rowCount++
+	for _, i := range c.notNull {
+		if i < len(values) && !values[i].Valid {
+			c.violate("row %d has NULL in non-nullable column %d", c.rowCount, i+1)
+		}
+	}
+	if c.distinct {
+		var key strings.Builder
+		for _, v := range values {
+			// Distinguish NULLs from the string "NULL".
+			fmt.Fprintf(&key, "%t%q,", v.Valid, v.String)
+		}
+		if c.seen[key.String()] {
+			c.violate("row %d is a duplicate despite DISTINCT", c.rowCount)
+		}
+		c.seen[key.String()] = true
+	}
+}
+
+// finish checks the result once it's been read, and returns the violations.
+// A nil check has none.
+func (c *resultCheck) finish() []string {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.scalar && c.rowCount > 1:
+		c.notes = append(c.notes, fmt.Sprintf("scalar subquery returned %d rows", c.rowCount))
+	case c.limit >= 0 && c.rowCount > c.limit:
+		c.violate("result has %d rows despite LIMIT %d", c.rowCount, c.limit)
+	}
+	return c.violations
+}
+
+// noted returns the notes on the result. A nil check has none.
+func (c *resultCheck) noted() []string {
+	if c == nil {
+		return nil
+	}
+	return c.notes
+}
+
+// walk calls f on expr and every relational and scalar expression below
+// it.
+func walk(expr interface{}, f func(interface{})) {
+	if expr == nil {
+		return
+	}
+	f(expr)
+	switch e := expr.(type) {
+	case *selectExpr:
+		for _, from := range e.fromClause {
+			walk(from, f)
+		}
+		for _, s := range e.selectList {
+			walk(s, f)
+		}
+		if e.filter != nil {
+			walk(e.filter, f)
+		}
+		for _, o := range e.orderBy {
+			walk(o, f)
+		}
+	case *join:
+		walk(e.lhs, f)
+		walk(e.rhs, f)
+		if e.on != nil {
+			walk(e.on, f)
+		}
+	case *insert:
+		walk(e.input, f)
+	case *insertReturning:
+		walk(e.input, f)
+		for _, r := range e.returning {
+			walk(r, f)
+		}
+	case *values:
+		for _, row := range e.values {
+			for _, v := range row {
+				walk(v, f)
+			}
+		}
+	case *setOp:
+		walk(e.left, f)
+		walk(e.right, f)
+	case *createView:
+		walk(e.body, f)
+	case *ddl:
+		walk(e.expr, f)
+	case *caseExpr:
+		walk(e.condition, f)
+		walk(e.trueExpr, f)
+		walk(e.falseExpr, f)
+	case *coalesceExpr:
+		walk(e.firstExpr, f)
+		walk(e.secondExpr, f)
+	case *opExpr:
+		walk(e.left, f)
+		walk(e.right, f)
+	case *funcExpr:
+		for _, in := range e.inputs {
+			walk(in, f)
+		}
+	case *castExpr:
+		walk(e.expr, f)
+	case *sequenceExpr:
+		if e.value != nil {
+			walk(e.value, f)
+		}
+	case *exists:
+		walk(e.subquery, f)
+	case *scalarSubq:
+		walk(e.subquery, f)
+	}
+}
+
+// scalarSubqueries returns the scalar subqueries in expr which are worth
+// executing on their own: those without LIMIT 1, which don't reference the
+// enclosing query, modify anything or call impure functions.
+func scalarSubqueries(expr relExpr) []relExpr {
+	var result []relExpr
+	walk(expr, func(e interface{}) {
+		if sub, ok := e.(*scalarSubq); ok && !sub.limited && isStandalone(sub.subquery) &&
+			!isImpure(sub.subquery) {
+			result = append(result, sub.subquery)
+		}
+	})
+	return result
+}
+
+func isStandalone(expr relExpr) bool {
+	defined := make(map[string]bool)
+	var used []string
+	standalone := true
+	walk(expr, func(e interface{}) {
+		switch e := e.(type) {
+		case *tableExpr:
+			defined[e.alias] = true
+		case *colRefExpr:
+			used = append(used, strings.SplitN(e.ref, ".", 2)[0])
+		case *insert, *insertReturning:
+			standalone = false
+		case *sequenceExpr:
+			standalone = standalone && e.fn == "currval"
+		}
+	})
+	for _, u := range used {
+		if !defined[u] {
+			return false
+		}
+	}
+	return standalone
+}
+
+// isImpure returns whether expr calls a function which may return something
+// different each time it's called, like random() or now(). Functions which
+// aren't builtins are assumed to be impure.
+func isImpure(expr relExpr) bool {
+	impure := false
+	walk(expr, func(e interface{}) {
+		if f, ok := e.(*funcExpr); ok {
+			def, ok := tree.FunDefs[f.name]
+			impure = impure || !ok || def.Impure
+		}
+	})
+	return impure
+}
+
+// isDeterministic returns whether the result of expr depends only on the
+// data it reads, and not on when it's executed: it calls no impure functions
+// or sequence functions, has no LIMIT which may pick any of the rows, and
+// doesn't return inserted rows, which may have random defaults.
+func isDeterministic(expr relExpr) bool {
+	deterministic := !isImpure(expr)
+	walk(expr, func(e interface{}) {
+		switch e := e.(type) {
+		case *sequenceExpr, *insertReturning:
+			deterministic = false
+		case *selectExpr:
+			deterministic = deterministic && e.limit == ""
+		}
+	})
+	return deterministic
+}
@@ -0,0 +1,225 @@
+package sqlsmith
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/lib/pq"
+)
+
+type indexColumn struct {
+	name       string
+	descending bool
+}
+
+type index struct {
+	name     string
+	cols     []indexColumn
+	storing  []string
+	unique   bool
+	primary  bool
+	inverted bool
+	// predicate is the predicate of a partial index, or empty if the index
+	// isn't partial.
+	predicate string
+}
+
+// hintable returns whether the index can be forced with an index hint
+// without the query being rejected outright.
+func (i index) hintable() bool {
+	return !i.inverted && i.predicate == ""
+}
+
+func (i index) colNames() []string {
+	names := make([]string, len(i.cols))
+	for j, c := range i.cols {
+		names[j] = c.name
+	}
+	return names
+}
+
+type foreignKey struct {
+	name string
+	cols []string
+	// refTable is the unqualified name of the referenced table, which must be
+	// in the same database.
+	refTable string
+	refCols  []string
+}
+
+func indexFromDef(def *tree.IndexTableDef, unique bool) index {
+	idx := index{
+		name:     string(def.Name),
+		unique:   unique,
+		inverted: def.Inverted,
+	}
+	for _, e := range def.Columns {
+		idx.cols = append(idx.cols, indexColumn{
+			name:       string(e.Column),
+			descending: e.Direction == tree.Descending,
+		})
+	}
+	for _, s := range def.Storing {
+		idx.storing = append(idx.storing, string(s))
+	}
+	return idx
+}
+
+func foreignKeyFromDef(def *tree.ForeignKeyConstraintTableDef) foreignKey {
+	fk := foreignKey{
+		name:     string(def.Name),
+		refTable: def.Table.Table(),
+	}
+	for _, c := range def.FromCols {
+		fk.cols = append(fk.cols, string(c))
+	}
+	for _, c := range def.ToCols {
+		fk.refCols = append(fk.refCols, string(c))
+	}
+	return fk
+}
+
+// parseIndexDef parses the CREATE INDEX statement in def, as found in
+// pg_indexes. The predicate of a partial index is split off before parsing
+// and returned as is.
+func parseIndexDef(def string) (index, error) {
+	var predicate string
+	if i := strings.LastIndex(def, " WHERE "); i >= 0 {
+		predicate = def[i+len(" WHERE "):]
+		def = def[:i]
+	}
+	stmt, err := parser.ParseOne(def)
+	if err != nil {
+		return index{}, err
+	}
+	create, ok := stmt.AST.(*tree.CreateIndex)
+	if !ok {
+		return index{}, fmt.Errorf("expected CREATE INDEX, found: %s", def)
+	}
+	idx := indexFromDef(&tree.IndexTableDef{
+		Name:     create.Name,
+		Columns:  create.Columns,
+		Storing:  create.Storing,
+		Inverted: create.Inverted,
+	}, create.Unique)
+	idx.predicate = predicate
+	return idx, nil
+}
+
+// parseConstraintDef parses a constraint definition, as returned by
+// pg_get_constraintdef.
+func parseConstraintDef(name, def string) (tree.ConstraintTableDef, error) {
+	stmt, err := parser.ParseOne(fmt.Sprintf(
+		"ALTER TABLE t ADD CONSTRAINT %s %s", tree.NameString(name), def,
+	))
+	if err != nil {
+		return nil, err
+	}
+	alter, ok := stmt.AST.(*tree.AlterTable)
+	if !ok || len(alter.Cmds) != 1 {
+		return nil, fmt.Errorf("unexpected constraint definition: %s", def)
+	}
+	add, ok := alter.Cmds[0].(*tree.AlterTableAddConstraint)
+	if !ok {
+		return nil, fmt.Errorf("unexpected constraint definition: %s", def)
+	}
+	return add.ConstraintDef, nil
+}
+
+// tableKey identifies a table within a database.
+type tableKey struct {
+	schema, name string
+}
+
+// extractConstraints loads the indexes and constraints of tables, which
+// were all loaded from database db, and attaches them to tables. Definitions
+// we fail to parse are skipped, rather than failing the whole extraction.
+func (s *schema) extractConstraints(db string, schemas []string, tables []table) error {
+	pgCatalog := inDatabase(db, "pg_catalog")
+	byKey := make(map[tableKey]*table, len(tables))
+	for i := range tables {
+		t := &tables[i]
+		byKey[tableKey{t.schema, t.name}] = t
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf(`
+	SELECT
+		schemaname, tablename, indexdef
+	FROM
+		%s.pg_indexes
+	WHERE
+		schemaname = ANY ($1)
+	`, pgCatalog), pq.Array(schemas))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var schemaName, tableName, def string
+		rows.Scan(&schemaName, &tableName, &def)
+		t, ok := byKey[tableKey{schemaName, tableName}]
+		if !ok {
+			continue
+		}
+		idx, err := parseIndexDef(def)
+		if err != nil {
+			continue
+		}
+		t.indexes = append(t.indexes, idx)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(fmt.Sprintf(`
+	SELECT
+		n.nspname, t.relname, c.conname, c.contype, pg_get_constraintdef(c.oid)
+	FROM
+		%[1]s.pg_constraint AS c
+		JOIN %[1]s.pg_class AS t ON c.conrelid = t.oid
+		JOIN %[1]s.pg_namespace AS n ON t.relnamespace = n.oid
+	WHERE
+		n.nspname = ANY ($1)
+	`, pgCatalog), pq.Array(schemas))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schemaName, tableName, name, typ, def string
+		rows.Scan(&schemaName, &tableName, &name, &typ, &def)
+		t, ok := byKey[tableKey{schemaName, tableName}]
+		if !ok {
+			continue
+		}
+		t.constraints = append(t.constraints, name)
+		switch typ {
+		case "p":
+			for i := range t.indexes {
+				if t.indexes[i].name == name {
+					t.indexes[i].primary = true
+				}
+			}
+		case "f":
+			c, err := parseConstraintDef(name, def)
+			if err != nil {
+				continue
+			}
+			if fk, ok := c.(*tree.ForeignKeyConstraintTableDef); ok {
+				t.foreignKeys = append(t.foreignKeys, foreignKeyFromDef(fk))
+			}
+		case "c":
+			c, err := parseConstraintDef(name, def)
+			if err != nil {
+				continue
+			}
+			if check, ok := c.(*tree.CheckConstraintTableDef); ok {
+				t.checks = append(t.checks, check.Expr.String())
+			}
+		}
+	}
+	return rows.Err()
+}
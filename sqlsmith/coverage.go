@@ -0,0 +1,185 @@
+package sqlsmith
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// coverage tracks which parts of the grammar were exercised during a run:
+// how often each production was attempted and how often it failed, how often
+// each retry loop gave up, and which operator and function overloads were
+// emitted and executed successfully. It is shared between workers. A nil
+// coverage tracks nothing.
+type coverage struct {
+	mu sync.Mutex
+
+	attempts map[string]int
+	failures map[string]int
+	// abandoned counts the times each retry loop gave up after retryCount
+	// attempts.
+	abandoned map[string]int
+
+	emitted  map[string]int
+	executed map[string]int
+}
+
+func makeCoverage() *coverage {
+	return &coverage{
+		attempts:  make(map[string]int),
+		failures:  make(map[string]int),
+		abandoned: make(map[string]int),
+		emitted:   make(map[string]int),
+		executed:  make(map[string]int),
+	}
+}
+
+func (c *coverage) attempt(production string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts[production]++
+}
+
+func (c *coverage) fail(production string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures[production]++
+}
+
+func (c *coverage) abandon(loop string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.abandoned[loop]++
+}
+
+// emit notes that a statement using overloads was generated.
+func (c *coverage) emit(overloads []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, o := range overloads {
+		c.emitted[o]++
+	}
+}
+
+// execute notes that a statement using overloads executed successfully.
+func (c *coverage) execute(overloads []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, o := range overloads {
+		c.executed[o]++
+	}
+}
+
+func (o operator) String() string {
+	return fmt.Sprintf("%s %s %s", o.left, o.name, o.right)
+}
+
+func (f function) String() string {
+	inputs := make([]string, len(f.inputs))
+	for i, typ := range f.inputs {
+		inputs[i] = typ.String()
+		if i >= len(f.inputs)-f.defaults {
+			inputs[i] += " default"
+		}
+	}
+	if f.variadic != nil {
+		inputs = append(inputs, "variadic "+f.variadic.String())
+	}
+	return fmt.Sprintf("%s(%s) -> %s", f.name, strings.Join(inputs, ", "), f.out)
+}
+
+// usedOverloads returns the operator and function overloads used in expr.
+// Only the finished expression counts, not parts of it which were discarded
+// while it was generated.
+func usedOverloads(expr interface{}) []string {
+	var result []string
+	walk(expr, func(e interface{}) {
+		switch e := e.(type) {
+		case *opExpr:
+			result = append(result, e.overload)
+		case *funcExpr:
+			result = append(result, e.overload)
+		}
+	})
+	return result
+}
+
+// print writes the coverage report to w. Overloads are listed against those
+// in schema, so those which were never emitted can be found.
+func (c *coverage) print(w io.Writer, schema *schema) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "-- coverage:\n")
+	// Include the productions which were never attempted.
+	names := sortedKeys(c.attempts)
+	for n := range defaultWeights {
+		if _, ok := c.attempts[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(w, "--   %-28s %8d attempted %8d succeeded\n",
+			n, c.attempts[n], c.attempts[n]-c.failures[n])
+	}
+	for _, n := range sortedKeys(c.abandoned) {
+		fmt.Fprintf(w, "--   %-28s %8d abandoned after %d retries\n", n, c.abandoned[n], retryCount)
+	}
+
+	schema.RLock()
+	var ops, fns []string
+	for _, overloads := range schema.operators {
+		for _, o := range overloads {
+			ops = append(ops, o.String())
+		}
+	}
+	for _, overloads := range schema.functions {
+		for _, f := range overloads {
+			fns = append(fns, f.String())
+		}
+	}
+	schema.RUnlock()
+	c.printOverloads(w, "operators", ops)
+	c.printOverloads(w, "functions", fns)
+}
+
+func (c *coverage) printOverloads(w io.Writer, kind string, all []string) {
+	sort.Strings(all)
+	var emitted, executed int
+	var never []string
+	for _, o := range all {
+		if c.emitted[o] == 0 {
+			never = append(never, o)
+			continue
+		}
+		emitted++
+		if c.executed[o] > 0 {
+			executed++
+		}
+	}
+	fmt.Fprintf(w, "--   %d/%d %s emitted, %d executed successfully\n", emitted, len(all), kind, executed)
+	if len(never) > 0 {
+		fmt.Fprintf(w, "--   %s never emitted:\n", kind)
+		for _, o := range never {
+			fmt.Fprintf(w, "--     %s\n", o)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+)
+
+// ddl is a schema change. Unlike the other statements, schema changes have no
+// structure anyone needs to look at after they're generated, so each
+// production formats its statement directly.
+type ddl struct {
+	// production is the name of the production which generated the
+	// statement.
+	production string
+	stmt       string
+	// expr is the scalar expression in the statement, if any, kept for
+	// coverage.
+	expr scalarExpr
+}
+
+func (d *ddl) Format(buf *bytes.Buffer) {
+	buf.WriteString(d.stmt)
+}
+
+func (d *ddl) Cols() []column {
+	return nil
+}
+
+func (s *scope) makeDDL() (*scope, bool) {
+	// Schema changes are formatted as they're generated, before placeholders
+	// are numbered, so they can't have any.
+	s = s.push()
+	s.prepare = false
+
+	for i := 0; i < retryCount; i++ {
+		production := s.pick(
+			"ddl.add_column",
+			"ddl.add_computed_column",
+			"ddl.drop_column",
+			"ddl.alter_column",
+			"ddl.create_index",
+			"ddl.drop_index",
+			"ddl.add_constraint",
+			"ddl.drop_constraint",
+			"ddl.rename",
+			"ddl.truncate",
+			"ddl.drop_table",
+		)
+		t, ok := s.pickTable(func(t table) bool { return t.isBaseTable && t.isInsertable })
+		if !ok {
+			return nil, false
+		}
+
+		var stmt string
+		var expr scalarExpr
+		switch production {
+		case "ddl.add_column":
+			stmt, expr, ok = s.makeAddColumn(t, false /* computed */)
+		case "ddl.add_computed_column":
+			stmt, expr, ok = s.makeAddColumn(t, true /* computed */)
+		case "ddl.drop_column":
+			stmt, ok = s.makeDropColumn(t)
+		case "ddl.alter_column":
+			stmt, ok = s.makeAlterColumn(t)
+		case "ddl.create_index":
+			stmt, expr, ok = s.makeCreateIndex(t)
+		case "ddl.drop_index":
+			stmt, ok = s.makeDropIndex(t)
+		case "ddl.add_constraint":
+			stmt, expr, ok = s.makeAddConstraint(t)
+		case "ddl.drop_constraint":
+			stmt, ok = s.makeDropConstraint(t)
+		case "ddl.rename":
+			stmt, ok = s.makeRename(t)
+		case "ddl.truncate":
+			stmt, ok = "truncate "+t.qualifiedName(), true
+		case "ddl.drop_table":
+			stmt, ok = "drop table "+t.qualifiedName(), true
+		default:
+			ok = false
+		}
+		if ok {
+			outScope := s.push()
+			outScope.expr = &ddl{production: production, stmt: stmt, expr: expr}
+			return outScope, true
+		}
+		s.coverage.fail(production)
+	}
+	s.coverage.abandon("ddl")
+	return nil, false
+}
+
+// pickTable returns a random table satisfying pred.
+func (s *scope) pickTable(pred func(table) bool) (table, bool) {
+	var tables []table
+	for _, t := range s.schema.tables {
+		if pred(t) {
+			tables = append(tables, t)
+		}
+	}
+	if len(tables) == 0 {
+		return table{}, false
+	}
+	return tables[s.rnd.Intn(len(tables))], true
+}
+
+// uniqueName returns a name starting with prefix which is unlikely to
+// already be in use.
+func (s *scope) uniqueName(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, s.rnd.Int())
+}
+
+// tableScope returns a scope in which the columns of t can be referenced
+// unqualified, as in computed columns, check constraints and partial index
+// predicates.
+func (s *scope) tableScope(t table) *scope {
+	out := s.push()
+	out.readOnly = true
+	out.scalarOnly = true
+	out.refs = []tableRef{tableExpr{rel: t}}
+	return out
+}
+
+// primaryKeyCols returns the names of the columns in t's primary key.
+func primaryKeyCols(t table) map[string]bool {
+	result := make(map[string]bool)
+	for _, idx := range t.indexes {
+		if idx.primary {
+			for _, c := range idx.cols {
+				result[c.name] = true
+			}
+		}
+	}
+	return result
+}
+
+func (s *scope) makeAddColumn(t table, computed bool) (string, scalarExpr, bool) {
+	typ := s.randType()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "alter table %s add column %s %s",
+		t.qualifiedName(), s.uniqueName("col"), typ.SQLName())
+	if computed {
+		if len(t.cols) == 0 {
+			return "", nil, false
+		}
+		expr, ok := s.tableScope(t).makeScalar(typ)
+		if !ok {
+			return "", nil, false
+		}
+		buf.WriteString(" as (")
+		expr.Format(&buf)
+		buf.WriteString(") stored")
+		return buf.String(), expr, true
+	}
+	if s.coin() {
+		buf.WriteString(" not null default ")
+		s.makeConstExpr(typ).Format(&buf)
+	}
+	return buf.String(), nil, true
+}
+
+func (s *scope) makeDropColumn(t table) (string, bool) {
+	pk := primaryKeyCols(t)
+	var cols []column
+	for _, c := range t.cols {
+		if !pk[c.name] {
+			cols = append(cols, c)
+		}
+	}
+	// Tables must have at least one column.
+	if len(cols) < 2 {
+		return "", false
+	}
+	c := cols[s.rnd.Intn(len(cols))]
+	return fmt.Sprintf("alter table %s drop column %s", t.qualifiedName(), tree.NameString(c.name)), true
+}
+
+func (s *scope) makeAlterColumn(t table) (string, bool) {
+	if len(t.cols) == 0 {
+		return "", false
+	}
+	c := t.cols[s.rnd.Intn(len(t.cols))]
+	prefix := fmt.Sprintf("alter table %s alter column %s", t.qualifiedName(), tree.NameString(c.name))
+	var stmt string
+	ok := false
+	production := s.pick(
+		"ddl.alter_column.set_default",
+		"ddl.alter_column.drop_default",
+		"ddl.alter_column.drop_not_null",
+	)
+	switch production {
+	case "ddl.alter_column.set_default":
+		if c.writability != writable {
+			break
+		}
+		var buf bytes.Buffer
+		buf.WriteString(prefix)
+		buf.WriteString(" set default ")
+		s.makeConstExpr(c.typ).Format(&buf)
+		stmt, ok = buf.String(), true
+	case "ddl.alter_column.drop_default":
+		stmt, ok = prefix+" drop default", true
+	case "ddl.alter_column.drop_not_null":
+		if c.nullable || primaryKeyCols(t)[c.name] {
+			break
+		}
+		stmt, ok = prefix+" drop not null", true
+	}
+	if !ok {
+		s.coverage.fail(production)
+	}
+	return stmt, ok
+}
+
+// isInvertible returns whether columns of type typ can be indexed by an
+// inverted index.
+func isInvertible(typ types.T) bool {
+	if _, ok := typ.(types.TArray); ok {
+		return true
+	}
+	return typ == types.JSON
+}
+
+func (s *scope) makeCreateIndex(t table) (string, scalarExpr, bool) {
+	if len(t.cols) == 0 {
+		return "", nil, false
+	}
+	var buf bytes.Buffer
+	if s.chance("ddl.index_inverted") {
+		var invertible []column
+		for _, c := range t.cols {
+			if isInvertible(c.typ) {
+				invertible = append(invertible, c)
+			}
+		}
+		if len(invertible) == 0 {
+			return "", nil, false
+		}
+		c := invertible[s.rnd.Intn(len(invertible))]
+		fmt.Fprintf(&buf, "create inverted index %s on %s (%s)",
+			s.uniqueName("idx"), t.qualifiedName(), tree.NameString(c.name))
+		return buf.String(), nil, true
+	}
+
+	buf.WriteString("create ")
+	if s.chance("ddl.index_unique") {
+		buf.WriteString("unique ")
+	}
+	fmt.Fprintf(&buf, "index %s on %s (", s.uniqueName("idx"), t.qualifiedName())
+	perm := s.rnd.Perm(len(t.cols))
+	n := 1 + s.rnd.Intn(len(perm))
+	if n > 3 {
+		n = 3
+	}
+	for i, j := range perm[:n] {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(tree.NameString(t.cols[j].name))
+		if s.coin() {
+			buf.WriteString(" desc")
+		}
+	}
+	buf.WriteString(")")
+
+	if n < len(perm) && s.chance("ddl.index_storing") {
+		buf.WriteString(" storing (")
+		for i, j := range perm[n : n+1+s.rnd.Intn(len(perm)-n)] {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(tree.NameString(t.cols[j].name))
+		}
+		buf.WriteString(")")
+	}
+
+	var pred scalarExpr
+	if s.chance("ddl.index_partial") {
+		var ok bool
+		if pred, ok = s.tableScope(t).makeBoolExpr(); !ok {
+			return "", nil, false
+		}
+		buf.WriteString(" where ")
+		pred.Format(&buf)
+	}
+	return buf.String(), pred, true
+}
+
+func (s *scope) makeDropIndex(t table) (string, bool) {
+	var names []string
+	for _, idx := range t.indexes {
+		if !idx.primary && idx.name != "" {
+			names = append(names, idx.name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("drop index %s@%s", t.qualifiedName(), tree.NameString(names[s.rnd.Intn(len(names))])), true
+}
+
+func (s *scope) makeAddConstraint(t table) (string, scalarExpr, bool) {
+	if len(t.cols) == 0 {
+		return "", nil, false
+	}
+	prefix := fmt.Sprintf("alter table %s add constraint %s", t.qualifiedName(), s.uniqueName("con"))
+	var stmt string
+	var check scalarExpr
+	ok := false
+	production := s.pick(
+		"ddl.add_constraint.unique",
+		"ddl.add_constraint.check",
+		"ddl.add_constraint.foreign_key",
+	)
+	switch production {
+	case "ddl.add_constraint.unique":
+		c := t.cols[s.rnd.Intn(len(t.cols))]
+		stmt, ok = fmt.Sprintf("%s unique (%s)", prefix, tree.NameString(c.name)), true
+	case "ddl.add_constraint.check":
+		if check, ok = s.tableScope(t).makeBoolExpr(); !ok {
+			break
+		}
+		var buf bytes.Buffer
+		buf.WriteString(prefix)
+		buf.WriteString(" check (")
+		check.Format(&buf)
+		buf.WriteString(")")
+		stmt = buf.String()
+	case "ddl.add_constraint.foreign_key":
+		stmt, ok = s.makeAddForeignKey(t, prefix)
+	}
+	if !ok {
+		s.coverage.fail(production)
+		return "", nil, false
+	}
+	return stmt, check, true
+}
+
+// makeAddForeignKey adds a foreign key from a column of t to a column of
+// the same type with a unique index on it, in a table in the same database.
+func (s *scope) makeAddForeignKey(t table, prefix string) (string, bool) {
+	type target struct {
+		from column
+		to   table
+		col  string
+	}
+	var targets []target
+	for _, other := range s.schema.tables {
+		if !other.isBaseTable || other.catalog != t.catalog {
+			continue
+		}
+		for _, idx := range other.indexes {
+			if !idx.unique || len(idx.cols) != 1 || idx.predicate != "" {
+				continue
+			}
+			to, ok := other.col(idx.cols[0].name)
+			if !ok {
+				continue
+			}
+			for _, from := range t.cols {
+				if from.typ.Equivalent(to.typ) {
+					targets = append(targets, target{from: from, to: other, col: to.name})
+				}
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return "", false
+	}
+	tg := targets[s.rnd.Intn(len(targets))]
+	return fmt.Sprintf("%s foreign key (%s) references %s (%s)",
+		prefix, tree.NameString(tg.from.name), tg.to.qualifiedName(), tree.NameString(tg.col)), true
+}
+
+func (s *scope) makeDropConstraint(t table) (string, bool) {
+	pk := make(map[string]bool)
+	for _, idx := range t.indexes {
+		if idx.primary {
+			pk[idx.name] = true
+		}
+	}
+	var names []string
+	for _, c := range t.constraints {
+		if !pk[c] {
+			names = append(names, c)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("alter table %s drop constraint %s",
+		t.qualifiedName(), tree.NameString(names[s.rnd.Intn(len(names))])), true
+}
+
+func (s *scope) makeRename(t table) (string, bool) {
+	var stmt string
+	ok := false
+	production := s.pick("ddl.rename.table", "ddl.rename.column", "ddl.rename.index")
+	switch production {
+	case "ddl.rename.table":
+		renamed := t
+		renamed.name = s.uniqueName("table")
+		stmt, ok = fmt.Sprintf("alter table %s rename to %s", t.qualifiedName(), renamed.qualifiedName()), true
+	case "ddl.rename.column":
+		if len(t.cols) == 0 {
+			break
+		}
+		c := t.cols[s.rnd.Intn(len(t.cols))]
+		stmt, ok = fmt.Sprintf("alter table %s rename column %s to %s",
+			t.qualifiedName(), tree.NameString(c.name), s.uniqueName("col")), true
+	case "ddl.rename.index":
+		var names []string
+		for _, idx := range t.indexes {
+			if !idx.primary && idx.name != "" {
+				names = append(names, idx.name)
+			}
+		}
+		if len(names) == 0 {
+			break
+		}
+		stmt, ok = fmt.Sprintf("alter index %s@%s rename to %s",
+			t.qualifiedName(), tree.NameString(names[s.rnd.Intn(len(names))]), s.uniqueName("idx")), true
+	}
+	if !ok {
+		s.coverage.fail(production)
+	}
+	return stmt, ok
+}
+
+// isDDL returns whether the statement e changes the schema, so the schema
+// should be reloaded after executing it.
+func isDDL(e relExpr) bool {
+	switch e.(type) {
+	case *ddl, *createView:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package sqlsmith
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// internalErrorCode is the SQLSTATE Cockroach uses for internal errors,
+// including recovered panics and most assertion failures.
+const internalErrorCode = "XX000"
+
+// generatorBugCode is the code statements sqlsmith-go generated which don't
+// round trip through the parser are recorded under.
+const generatorBugCode = "generator bug"
+
+// resultCheckCode is the code statements whose results fail the checks in
+// checks.go are recorded under.
+const resultCheckCode = "result check"
+
+// undefinedTableCode is the SQLSTATE of errors about tables which don't
+// exist.
+const undefinedTableCode = "42P01"
+
+// retryErrorCode is the SQLSTATE of errors asking the client to retry the
+// transaction.
+const retryErrorCode = "40001"
+
+// unknownErrorCode is used to bucket errors that didn't come back from the
+// server as a pq.Error, like driver or network errors.
+const unknownErrorCode = "unknown"
+
+type errorClass int
+
+const (
+	// errorAllowed is an error we expect to see, and don't want to hear about.
+	errorAllowed errorClass = iota
+	// errorExpected is an ordinary error (usually failing semantic analysis)
+	// that wasn't explicitly allowed.
+	errorExpected
+	// errorInternal is an error that indicates a bug in the server, and should
+	// be reported as a finding.
+	errorInternal
+)
+
+// assertionMessages are substrings of error messages which indicate an
+// assertion failure in the server regardless of the error code attached.
+var assertionMessages = []string{
+	"assertion failure",
+	"programming error",
+	"internal error",
+}
+
+// allowlist is the set of errors which are expected, and should not be
+// reported. Internal errors are never allowed.
+type allowlist struct {
+	codes    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func makeAllowlist(codes []string, patterns []*regexp.Regexp) allowlist {
+	a := allowlist{
+		codes:    make(map[string]bool, len(codes)),
+		patterns: patterns,
+	}
+	for _, c := range codes {
+		a.codes[strings.ToUpper(strings.TrimSpace(c))] = true
+	}
+	return a
+}
+
+func (a allowlist) allows(code, msg string) bool {
+	if a.codes[code] {
+		return true
+	}
+	for _, p := range a.patterns {
+		if p.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorCode returns the SQLSTATE of err, if it has one.
+func errorCode(err error) string {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return string(pqErr.Code)
+	}
+	return unknownErrorCode
+}
+
+// classify determines how an error returned from executing a generated
+// statement should be treated.
+func (a allowlist) classify(err error) errorClass {
+	code := errorCode(err)
+	msg := err.Error()
+	if code == internalErrorCode {
+		return errorInternal
+	}
+	for _, m := range assertionMessages {
+		if strings.Contains(msg, m) {
+			return errorInternal
+		}
+	}
+	// Transactions are expected to conflict with those of other workers.
+	if code == retryErrorCode || a.allows(code, msg) {
+		return errorAllowed
+	}
+	return errorExpected
+}
@@ -0,0 +1,63 @@
+package sqlsmith
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestClassify(t *testing.T) {
+	a := makeAllowlist([]string{" 42p01 "}, []*regexp.Regexp{regexp.MustCompile("division by zero")})
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected errorClass
+	}{
+		{
+			name:     "expected",
+			err:      &pq.Error{Code: "42703", Message: `column "x" does not exist`},
+			expected: errorExpected,
+		},
+		{
+			name:     "allowed code",
+			err:      &pq.Error{Code: "42P01", Message: `relation "t" does not exist`},
+			expected: errorAllowed,
+		},
+		{
+			name:     "allowed pattern",
+			err:      &pq.Error{Code: "22012", Message: "division by zero"},
+			expected: errorAllowed,
+		},
+		{
+			name:     "retry",
+			err:      &pq.Error{Code: "40001", Message: "restart transaction"},
+			expected: errorAllowed,
+		},
+		{
+			name:     "internal",
+			err:      &pq.Error{Code: "XX000", Message: "division by zero"},
+			expected: errorInternal,
+		},
+		{
+			name:     "assertion with an allowed code",
+			err:      &pq.Error{Code: "42P01", Message: "programming error: no table"},
+			expected: errorInternal,
+		},
+		{
+			name:     "assertion without a code",
+			err:      errors.New("internal error: unexpected"),
+			expected: errorInternal,
+		},
+		{
+			name:     "driver error",
+			err:      errors.New("driver: bad connection"),
+			expected: errorExpected,
+		},
+	} {
+		if result := a.classify(tc.err); result != tc.expected {
+			t.Errorf("%s: classified as %d, expected %d", tc.name, result, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package sqlsmith
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+)
+
+// Rather than picking a production at random and retrying when it turns out
+// to be impossible, such as a column reference when no column of the right
+// type is in scope, each production declares what it needs in order to
+// succeed. Only the productions which are feasible for the requested type,
+// the scope and the remaining depth are picked between, and leaves which
+// fail aren't picked again for the same hole.
+
+// feasibility describes what a production needs in order to succeed.
+type feasibility struct {
+	// recursive productions generate further expressions or relations below
+	// them, so need room to go deeper.
+	recursive bool
+	// canMake returns whether the production can make an expression of type
+	// typ in scope s. If nil, it always can.
+	canMake func(s *scope, typ types.T) bool
+}
+
+var feasibilities = map[string]feasibility{
+	"stmt.insert":    {canMake: (*scope).canInsert},
+	"stmt.ddl":       {canMake: (*scope).canAlter},
+	"stmt.returning": {recursive: true},
+
+	"returning.select": {canMake: (*scope).hasTables},
+	"returning.values": {recursive: true},
+	"returning.setop":  {recursive: true},
+
+	"source.table":            {canMake: (*scope).hasTables},
+	"source.join":             {recursive: true, canMake: (*scope).canMakeJoin},
+	"source.insert_returning": {recursive: true, canMake: (*scope).canInsertReturning},
+
+	"scalar.const":    {canMake: canMakeConst},
+	"scalar.colref":   {canMake: (*scope).hasColumn},
+	"scalar.sequence": {canMake: (*scope).canCallSequence},
+	"scalar.case":     {recursive: true},
+	"scalar.coalesce": {recursive: true},
+	"scalar.binop":    {recursive: true, canMake: (*scope).hasOperator},
+	"scalar.func":     {recursive: true, canMake: (*scope).hasFunction},
+	"scalar.subquery": {recursive: true, canMake: (*scope).canSubquery},
+
+	"bool.binop":  {recursive: true, canMake: (*scope).hasOperator},
+	"bool.scalar": {},
+	"bool.exists": {recursive: true, canMake: (*scope).canSubquery},
+}
+
+// feasible returns those of candidates which can make an expression of type
+// typ in this scope. Recursive productions are only included if deeper is
+// set and the budget leaves room for them.
+func (s *scope) feasible(typ types.T, deeper bool, candidates ...string) []string {
+	var result []string
+	for _, c := range candidates {
+		f, ok := feasibilities[c]
+		if !ok {
+			panic("no feasibility for production " + c)
+		}
+		if f.recursive && !(deeper && s.canRecurse()) {
+			continue
+		}
+		if f.canMake != nil && !f.canMake(s, typ) {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// without returns candidates without the production name.
+func without(candidates []string, name string) []string {
+	result := candidates[:0:0]
+	for _, c := range candidates {
+		if c != name {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func (s *scope) hasTables(types.T) bool {
+	return len(s.schema.tables) > 0
+}
+
+func (s *scope) canInsert(types.T) bool {
+	if !s.canMutate() {
+		return false
+	}
+	for _, t := range s.schema.tables {
+		if t.isInsertable {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *scope) canMakeJoin(typ types.T) bool {
+	return s.canJoin() && s.hasTables(typ)
+}
+
+func (s *scope) canInsertReturning(typ types.T) bool {
+	return s.canNest() && s.canInsert(typ)
+}
+
+func (s *scope) canAlter(types.T) bool {
+	if !s.canMutate() {
+		return false
+	}
+	for _, t := range s.schema.tables {
+		if t.isBaseTable && t.isInsertable {
+			return true
+		}
+	}
+	return false
+}
+
+func canMakeConst(_ *scope, typ types.T) bool {
+	if typ == types.Any {
+		return true
+	}
+	_, err := sqlbase.DatumTypeToColumnType(typ)
+	return err == nil
+}
+
+func (s *scope) hasColumn(typ types.T) bool {
+	cols, _ := s.columns().candidates(typ)
+	return len(cols) > 0
+}
+
+func (s *scope) canCallSequence(typ types.T) bool {
+	return typ == types.Int && len(s.schema.sequences) > 0 && !s.scalarOnly
+}
+
+func (s *scope) canSubquery(types.T) bool {
+	return !s.scalarOnly && s.canNest() && len(s.schema.tables) > 0
+}
+
+// hasOperator and hasFunction treat types.Any as feasible, since
+// makeBinOp and makeFunc replace it with a random type.
+func (s *scope) hasOperator(typ types.T) bool {
+	return typ == types.Any || len(s.schema.GetOperatorsByOutputType(typ)) > 0
+}
+
+func (s *scope) hasFunction(typ types.T) bool {
+	return typ == types.Any || len(s.schema.GetFunctionsByOutputType(typ)) > 0
+}
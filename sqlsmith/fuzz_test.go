@@ -0,0 +1,35 @@
+package sqlsmith
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// FuzzGenerate generates a statement against the test schema, with every
+// random decision taken from the fuzz input, and fails if it doesn't parse.
+// Syntax the parser knows but doesn't implement is allowed.
+func FuzzGenerate(f *testing.F) {
+	schema, err := loadSnapshot("testdata/schema.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+	// The input is a stream of random decisions, not SQL.
+	f.Add([]byte{})
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	f.Add([]byte{255, 128, 64, 32, 16, 8, 4, 2, 1, 0})
+	f.Add([]byte{7, 250, 3, 99, 180, 42, 0, 211, 17, 64, 128, 5, 33, 240, 12, 77})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := newSmither(schema, rand.New(NewByteSource(data)))
+		for i := 0; i < retryCount; i++ {
+			expr, ok := s.generate(AnyStatement)
+			if !ok {
+				continue
+			}
+			if _, err := toStatement(expr); err != nil && !isUnimplemented(err) {
+				t.Fatal(err)
+			}
+			return
+		}
+	})
+}
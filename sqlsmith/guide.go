@@ -0,0 +1,177 @@
+package sqlsmith
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// When the server is built with coverage instrumentation, runs can be
+// guided by it, much like libFuzzer: after each statement the coverage of the
+// server is collected, statements which reached code no earlier statement
+// did are kept, and those statements are preferentially mutated to make new
+// ones. Since coverage is collected for the whole server, it's only
+// attributed accurately to statements when there's a single worker.
+
+// CoverageCollector collects the code coverage of the server under test.
+type CoverageCollector interface {
+	// Collect returns the coverage points, such as basic blocks, which the
+	// server has reached so far. Points are identified by arbitrary strings,
+	// which must be stable over the run.
+	Collect() ([]string, error)
+}
+
+// FileCoverageCollector collects coverage from a file the server writes its
+// coverage profile to, in the format written by go test -coverprofile. The
+// server is expected to keep the file up to date, for instance by rewriting
+// it after each statement.
+type FileCoverageCollector struct {
+	Path string
+}
+
+var _ CoverageCollector = FileCoverageCollector{}
+
+// Collect implements CoverageCollector. Each block in the profile with a
+// non-zero count is a point.
+func (c FileCoverageCollector) Collect() ([]string, error) {
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCoverProfile(f)
+}
+
+// parseCoverProfile returns the blocks reached in a coverage profile. Each
+// line after the mode line is of the form
+//
+//	file.go:startLine.startCol,endLine.endCol numStatements count
+func parseCoverProfile(r io.Reader) ([]string, error) {
+	var result []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed coverage profile line %q", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("malformed coverage profile line %q", line)
+		}
+		if count > 0 {
+			result = append(result, fields[0])
+		}
+	}
+	return result, scanner.Err()
+}
+
+// guide keeps the statements which reached new coverage. It is shared
+// between workers.
+type guide struct {
+	collector CoverageCollector
+	// out, if set, is where kept statements are written, so they can seed a
+	// later run with -mutate.
+	out io.WriteCloser
+
+	mu   sync.Mutex
+	seen map[string]bool
+	// corpus are the statements which reached new coverage, and gains how
+	// many new points each did.
+	corpus []string
+	gains  []int
+	total  int
+}
+
+// makeGuide returns a guide which collects coverage with collector. The
+// coverage the server has already reached is collected straight away, so it
+// isn't credited to the first statement.
+func makeGuide(collector CoverageCollector, out io.WriteCloser) (*guide, error) {
+	g := &guide{
+		collector: collector,
+		out:       out,
+		seen:      make(map[string]bool),
+	}
+	if _, err := g.update(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// update collects coverage and marks the points reached as seen. It returns
+// the number of new points.
+func (g *guide) update() (int, error) {
+	points, err := g.collector.Collect()
+	if err != nil {
+		return 0, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gain := 0
+	for _, p := range points {
+		if !g.seen[p] {
+			g.seen[p] = true
+			gain++
+		}
+	}
+	return gain, nil
+}
+
+// observe collects coverage after stmt executed, keeping stmt if it reached
+// new points. It returns the number of new points.
+func (g *guide) observe(stmt string) (int, error) {
+	gain, err := g.update()
+	if err != nil || gain == 0 {
+		return 0, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.corpus = append(g.corpus, stmt)
+	g.gains = append(g.gains, gain)
+	g.total += gain
+	if g.out != nil {
+		if _, err := fmt.Fprintf(g.out, "%s;\n\n", stmt); err != nil {
+			return gain, err
+		}
+	}
+	return gain, nil
+}
+
+// pick returns a kept statement, with probability proportional to how much
+// new coverage it reached, or false if none have been kept.
+func (g *guide) pick(rnd *rand.Rand) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.total == 0 {
+		return "", false
+	}
+	r := rnd.Intn(g.total)
+	for i, gain := range g.gains {
+		r -= gain
+		if r < 0 {
+			return g.corpus[i], true
+		}
+	}
+	panic("unreachable")
+}
+
+func (g *guide) print(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintf(w, "-- coverage guidance: %d points reached, %d statements kept\n", len(g.seen), len(g.corpus))
+}
+
+func (g *guide) Close() error {
+	if g.out == nil {
+		return nil
+	}
+	return g.out.Close()
+}
@@ -0,0 +1,42 @@
+package sqlsmith
+
+import "testing"
+
+// fakeCollector reports the points it's been told the server reached.
+type fakeCollector struct {
+	points []string
+}
+
+func (c *fakeCollector) Collect() ([]string, error) {
+	return c.points, nil
+}
+
+func TestGuide(t *testing.T) {
+	c := &fakeCollector{points: []string{"startup"}}
+	g, err := makeGuide(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Coverage reached by setting up the database isn't credited to the
+	// next statement.
+	c.points = append(c.points, "setup")
+	if _, err := g.update(); err != nil {
+		t.Fatal(err)
+	}
+	c.points = append(c.points, "select")
+	if gain, err := g.observe("select 1"); err != nil {
+		t.Fatal(err)
+	} else if gain != 1 {
+		t.Errorf("expected 1 new point, got %d", gain)
+	}
+
+	if gain, err := g.observe("select 2"); err != nil {
+		t.Fatal(err)
+	} else if gain != 0 {
+		t.Errorf("expected no new points, got %d", gain)
+	}
+	if len(g.corpus) != 1 || g.corpus[0] != "select 1" {
+		t.Errorf("expected only select 1 to be kept, got %v", g.corpus)
+	}
+}
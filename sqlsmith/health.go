@@ -0,0 +1,137 @@
+package sqlsmith
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type healthStatus int
+
+const (
+	healthy healthStatus = iota
+	// hung means a statement didn't complete within the statement timeout.
+	hung
+	// crashed means the connection to the server was lost, either because the
+	// server died or because it restarted underneath us.
+	crashed
+)
+
+func (h healthStatus) String() string {
+	switch h {
+	case healthy:
+		return "healthy"
+	case hung:
+		return "timeout"
+	case crashed:
+		return "crash"
+	default:
+		return fmt.Sprintf("healthStatus(%d)", int(h))
+	}
+}
+
+// connectionErrors are substrings of error messages which indicate the
+// connection to the server was lost.
+var connectionErrors = []string{
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"bad connection",
+	"unexpected EOF",
+	"server closed the connection",
+}
+
+func isConnectionError(err error) bool {
+	if err == driver.ErrBadConn || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	for _, m := range connectionErrors {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// healthChecker determines whether the server is still alive after a
+// statement returns an error.
+type healthChecker struct {
+	db *sql.DB
+
+	// mu serializes recovery, since several workers may notice the same crash.
+	mu sync.Mutex
+
+	// pingTimeout bounds how long we wait for the server to respond to a ping.
+	pingTimeout time.Duration
+
+	// reconnectTimeout bounds how long we wait for the server to come back
+	// after it crashed.
+	reconnectTimeout time.Duration
+
+	// restart, if set, is called to bring the server back after a crash.
+	restart func() error
+}
+
+func (h *healthChecker) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.pingTimeout)
+	defer cancel()
+	return h.db.PingContext(ctx)
+}
+
+// check returns the health of the server after a statement executed with ctx
+// returned err. Internal errors are handled by classify, since the server is
+// still up when it returns one.
+func (h *healthChecker) check(ctx context.Context, err error) healthStatus {
+	if ctx.Err() == context.DeadlineExceeded {
+		// The server may have hung for good, rather than just this statement.
+		if h.ping() != nil {
+			return crashed
+		}
+		return hung
+	}
+	if isConnectionError(err) {
+		return crashed
+	}
+	if h.ping() != nil {
+		return crashed
+	}
+	return healthy
+}
+
+// recover waits for the server to come back after a crash, running the
+// restart hook first if there is one.
+func (h *healthChecker) recover() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ping() == nil {
+		// Either the server came back on its own, or another worker already
+		// brought it back.
+		return nil
+	}
+	if h.restart != nil {
+		if err := h.restart(); err != nil {
+			return fmt.Errorf("restart hook failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(h.reconnectTimeout)
+	for {
+		err := h.ping()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server did not come back after %s: %v", h.reconnectTimeout, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,73 @@
+package sqlsmith
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeConnector connects to a server which is up unless err is set, in which
+// case connecting fails with it.
+type fakeConnector struct {
+	err error
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return fakeConn{}, nil
+}
+
+func (fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestHealthCheck(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		down     bool
+		expected healthStatus
+	}{
+		{name: "error", ctx: context.Background(), err: errors.New("syntax error"), expected: healthy},
+		{name: "error while down", ctx: context.Background(), err: errors.New("syntax error"), down: true, expected: crashed},
+		{name: "connection error", ctx: context.Background(), err: errors.New("read: connection reset by peer"), expected: crashed},
+		{name: "bad connection", ctx: context.Background(), err: driver.ErrBadConn, expected: crashed},
+		{name: "timeout", ctx: expired, err: context.DeadlineExceeded, expected: hung},
+		{name: "timeout while down", ctx: expired, err: context.DeadlineExceeded, down: true, expected: crashed},
+	} {
+		c := fakeConnector{}
+		if tc.down {
+			c.err = errors.New("dial tcp: connection refused")
+		}
+		db := sql.OpenDB(c)
+		h := &healthChecker{db: db, pingTimeout: time.Second}
+		if result := h.check(tc.ctx, tc.err); result != tc.expected {
+			t.Errorf("%s: checked as %s, expected %s", tc.name, result, tc.expected)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
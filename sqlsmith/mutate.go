@@ -0,0 +1,574 @@
+package sqlsmith
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/coltypes"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+)
+
+// Besides generating statements from scratch, sqlsmith-go can mutate
+// existing ones, read from a corpus of SQL like Cockroach's logic tests or
+// queries captured from an application. Each mutation works on the parsed
+// statement and keeps it well-typed: subexpressions are only replaced by
+// generated expressions of the same type, and tables only by tables with the
+// columns the statement might reference.
+
+// LoadCorpus reads the statements in the files at paths. Files ending in
+// .sql are read as semicolon-separated statements, and anything else as a
+// logic test file, from which the statements of statement and query blocks
+// are taken. Statements which don't parse are skipped.
+func LoadCorpus(paths ...string) ([]string, error) {
+	var result []string
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var blocks []string
+		if filepath.Ext(path) == ".sql" {
+			blocks = []string{string(contents)}
+		} else {
+			blocks = logicTestStatements(contents)
+		}
+		for _, b := range blocks {
+			stmts, err := parser.Parse(b)
+			if err != nil {
+				continue
+			}
+			for _, stmt := range stmts {
+				result = append(result, stmt.AST.String())
+			}
+		}
+	}
+	return result, nil
+}
+
+// logicTestStatements returns the SQL of the statement and query blocks in
+// a logic test file.
+func logicTestStatements(contents []byte) []string {
+	var result []string
+	var block []string
+	inBlock := false
+	flush := func() {
+		if len(block) > 0 {
+			result = append(result, strings.Join(block, "\n"))
+		}
+		block = nil
+		inBlock = false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case !inBlock:
+			fields := strings.Fields(line)
+			if len(fields) > 0 && (fields[0] == "statement" || fields[0] == "query") {
+				inBlock = true
+			}
+		case strings.TrimSpace(line) == "" || line == "----":
+			flush()
+		default:
+			block = append(block, line)
+		}
+	}
+	flush()
+	return result
+}
+
+// Corpus sets the statements the Smither mutates, as returned by LoadCorpus.
+func Corpus(stmts []string) SmitherOption {
+	return func(s *Smither) {
+		s.corpus = stmts
+	}
+}
+
+// Mutate returns a random mutation of stmt, which must parse.
+func (s *Smither) Mutate(stmt string) (string, error) {
+	ast, _, err := s.mutate(stmt)
+	if err != nil {
+		return "", err
+	}
+	return prettyPrint(ast), nil
+}
+
+// generateMutation returns a mutation of a random statement from the
+// corpus.
+func (s *Smither) generateMutation() (statement, bool) {
+	if len(s.corpus) == 0 {
+		return statement{}, false
+	}
+	return s.makeMutation(s.corpus[s.rnd.Intn(len(s.corpus))])
+}
+
+// makeMutation returns a mutation of stmt to execute.
+func (s *Smither) makeMutation(stmt string) (statement, bool) {
+	ast, overloads, err := s.mutate(stmt)
+	if err != nil {
+		return statement{}, false
+	}
+	s.coverage.emit(overloads)
+	return statement{
+		production: "mutation",
+		stmt:       prettyPrint(ast),
+		overloads:  overloads,
+		generated:  true,
+		ddl:        ast.StatementType() == tree.DDL,
+	}, true
+}
+
+func (s *Smither) mutate(stmt string) (tree.Statement, []string, error) {
+	s.schema.RLock()
+	defer s.schema.RUnlock()
+
+	// Parse the statement afresh, so it can be mutated in place.
+	parsed, err := parser.ParseOne(stmt)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc := s.makeScope()
+	m := mutator{scope: sc, stmt: parsed.AST}
+	for n := 1 + s.rnd.Intn(3); n > 0; n-- {
+		production := sc.pick("mutate.expr", "mutate.table", "mutate.predicate", "mutate.join")
+		var ok bool
+		switch production {
+		case "mutate.expr":
+			ok = m.mutateExpr()
+		case "mutate.table":
+			ok = m.mutateTable()
+		case "mutate.predicate":
+			ok = m.mutatePredicate()
+		case "mutate.join":
+			ok = m.mutateJoin()
+		}
+		if !ok {
+			s.coverage.fail(production)
+		}
+	}
+	return m.stmt, m.overloads, nil
+}
+
+// mutator mutates a parsed statement in place.
+type mutator struct {
+	*scope
+	stmt tree.Statement
+	// overloads are the operator and function overloads used in the
+	// expressions the mutations added.
+	overloads []string
+}
+
+// selectClauses returns the SELECT clauses of the statement, including
+// those of subqueries.
+func (m *mutator) selectClauses() []*tree.SelectClause {
+	var result []*tree.SelectClause
+	var addSelect func(sel tree.SelectStatement)
+	addTables := func(tables tree.TableExprs) {
+		for _, t := range tables {
+			for _, a := range aliasedTables(t) {
+				if sub, ok := a.Expr.(*tree.Subquery); ok {
+					addSelect(sub.Select)
+				}
+			}
+		}
+	}
+	addSelect = func(sel tree.SelectStatement) {
+		switch sel := sel.(type) {
+		case *tree.SelectClause:
+			result = append(result, sel)
+			if sel.From != nil {
+				addTables(sel.From.Tables)
+			}
+		case *tree.ParenSelect:
+			addSelect(sel.Select.Select)
+		case *tree.UnionClause:
+			addSelect(sel.Left.Select)
+			addSelect(sel.Right.Select)
+		}
+	}
+	switch stmt := m.stmt.(type) {
+	case *tree.Select:
+		addSelect(stmt.Select)
+	case *tree.Insert:
+		if stmt.Rows != nil {
+			addSelect(stmt.Rows.Select)
+		}
+	}
+
+	// Subqueries in expressions have clauses of their own.
+	for i := 0; i < len(result); i++ {
+		for _, e := range clauseExprs(result[i]) {
+			tree.WalkExprConst(subqueryVisitor(func(sub *tree.Subquery) {
+				addSelect(sub.Select)
+			}), *e)
+		}
+	}
+	return result
+}
+
+type subqueryVisitor func(*tree.Subquery)
+
+func (v subqueryVisitor) VisitPre(expr tree.Expr) (bool, tree.Expr) {
+	if sub, ok := expr.(*tree.Subquery); ok {
+		v(sub)
+		return false, expr
+	}
+	return true, expr
+}
+
+func (v subqueryVisitor) VisitPost(expr tree.Expr) tree.Expr {
+	return expr
+}
+
+// aliasedTables returns the tables and subqueries making up t.
+func aliasedTables(t tree.TableExpr) []*tree.AliasedTableExpr {
+	switch t := t.(type) {
+	case *tree.AliasedTableExpr:
+		return []*tree.AliasedTableExpr{t}
+	case *tree.ParenTableExpr:
+		return aliasedTables(t.Expr)
+	case *tree.JoinTableExpr:
+		return append(aliasedTables(t.Left), aliasedTables(t.Right)...)
+	}
+	return nil
+}
+
+// joins returns the joins in t.
+func joins(t tree.TableExpr) []*tree.JoinTableExpr {
+	switch t := t.(type) {
+	case *tree.ParenTableExpr:
+		return joins(t.Expr)
+	case *tree.JoinTableExpr:
+		return append([]*tree.JoinTableExpr{t}, append(joins(t.Left), joins(t.Right)...)...)
+	}
+	return nil
+}
+
+// clauseExprs returns pointers to the expressions in c which can be
+// replaced.
+func clauseExprs(c *tree.SelectClause) []*tree.Expr {
+	var result []*tree.Expr
+	for i := range c.Exprs {
+		result = append(result, &c.Exprs[i].Expr)
+	}
+	if c.Where != nil {
+		result = append(result, &c.Where.Expr)
+	}
+	if c.Having != nil {
+		result = append(result, &c.Having.Expr)
+	}
+	if c.From != nil {
+		for _, t := range c.From.Tables {
+			for _, j := range joins(t) {
+				if on, ok := j.Cond.(*tree.OnJoinCond); ok {
+					result = append(result, &on.Expr)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// lookupTable returns the table in the schema named by tn.
+func (m *mutator) lookupTable(tn *tree.TableName) (table, bool) {
+	for _, t := range m.schema.tables {
+		if t.name == tn.Table() && namesSchema(tn, t) {
+			return t, true
+		}
+	}
+	return table{}, false
+}
+
+// namesSchema returns whether the prefix of tn names t's schema. Tables whose
+// schema or database we don't know match any. An unqualified name only
+// matches the schemas on the default search path, and a name with one part
+// of prefix may name either a schema or a database, as it may in Cockroach.
+func namesSchema(tn *tree.TableName, t table) bool {
+	schema := t.schema
+	if schema == "" {
+		schema = tree.PublicSchema
+	}
+	sameCatalog := func(name string) bool {
+		return t.catalog == "" || t.catalog == name
+	}
+	switch {
+	case tn.ExplicitCatalog:
+		return sameCatalog(tn.Catalog()) && schema == tn.Schema()
+	case tn.ExplicitSchema:
+		return schema == tn.Schema() ||
+			(schema == tree.PublicSchema && sameCatalog(tn.Schema()))
+	default:
+		return schema == tree.PublicSchema || schema == "pg_catalog"
+	}
+}
+
+// clauseScope returns a scope in which the tables in c's FROM clause can be
+// referenced.
+func (m *mutator) clauseScope(c *tree.SelectClause) *scope {
+	out := m.push()
+	if c.From == nil {
+		return out
+	}
+	for _, t := range c.From.Tables {
+		for _, a := range aliasedTables(t) {
+			tn, ok := a.Expr.(*tree.TableName)
+			if !ok {
+				continue
+			}
+			rel, ok := m.lookupTable(tn)
+			if !ok {
+				continue
+			}
+			alias := string(a.As.Alias)
+			if alias == "" {
+				alias = rel.name
+			}
+			out.refs = append(out.refs, tableExpr{rel: rel, alias: alias})
+		}
+	}
+	return out
+}
+
+// pickClause returns a random SELECT clause of the statement.
+func (m *mutator) pickClause() (*tree.SelectClause, bool) {
+	clauses := m.selectClauses()
+	if len(clauses) == 0 {
+		return nil, false
+	}
+	return clauses[m.rnd.Intn(len(clauses))], true
+}
+
+// staticType returns the type of e, if it can be known without resolving
+// any names.
+func staticType(e tree.Expr) (types.T, bool) {
+	switch e := e.(type) {
+	case *tree.ComparisonExpr, *tree.AndExpr, *tree.OrExpr, *tree.NotExpr,
+		*tree.IsOfTypeExpr, *tree.RangeCond:
+		return types.Bool, true
+	case *tree.CastExpr:
+		typ := coltypes.CastTargetToDatumType(e.Type)
+		return typ, canMakeConst(nil, typ)
+	case tree.Datum:
+		if e == tree.DNull {
+			return nil, false
+		}
+		return e.ResolvedType(), true
+	}
+	return nil, false
+}
+
+// replaceVisitor replaces the n'th expression with a known type by calling
+// replace, or just counts them if replace is nil.
+type replaceVisitor struct {
+	n       int
+	seen    int
+	replace func(types.T) (tree.Expr, bool)
+}
+
+func (v *replaceVisitor) VisitPre(expr tree.Expr) (bool, tree.Expr) {
+	if _, ok := expr.(*tree.Subquery); ok {
+		// Subqueries are mutated as clauses of their own.
+		return false, expr
+	}
+	typ, ok := staticType(expr)
+	if !ok {
+		return true, expr
+	}
+	v.seen++
+	if v.replace == nil || v.seen-1 != v.n {
+		return true, expr
+	}
+	if e, ok := v.replace(typ); ok {
+		return false, e
+	}
+	return true, expr
+}
+
+func (v *replaceVisitor) VisitPost(expr tree.Expr) tree.Expr {
+	return expr
+}
+
+// mutateExpr replaces a subexpression of known type with a generated one of
+// the same type, cast to it.
+func (m *mutator) mutateExpr() bool {
+	c, ok := m.pickClause()
+	if !ok {
+		return false
+	}
+	roots := clauseExprs(c)
+	counter := &replaceVisitor{}
+	for _, r := range roots {
+		tree.WalkExprConst(counter, *r)
+	}
+	if counter.seen == 0 {
+		return false
+	}
+
+	sc := m.clauseScope(c)
+	replaced := false
+	v := &replaceVisitor{
+		n: m.rnd.Intn(counter.seen),
+		replace: func(typ types.T) (tree.Expr, bool) {
+			e, ok := sc.makeScalar(typ)
+			if !ok {
+				return nil, false
+			}
+			expr, err := toExpr(e)
+			if err != nil {
+				return nil, false
+			}
+			// Cockroach evaluates arithmetic on constants exactly, so an
+			// expression generated as an INT may type check as a DECIMAL.
+			// The cast keeps the type of the expression it replaces.
+			colTyp, err := coltypes.DatumTypeToColumnType(typ)
+			if err != nil {
+				return nil, false
+			}
+			replaced = true
+			m.overloads = append(m.overloads, usedOverloads(e)...)
+			return &tree.CastExpr{Expr: expr, Type: colTyp, SyntaxMode: tree.CastShort}, true
+		},
+	}
+	for _, r := range roots {
+		*r, _ = tree.WalkExpr(v, *r)
+	}
+	return replaced
+}
+
+// mutateTable replaces a table with another which has all of its columns,
+// keeping the name it's referenced by.
+func (m *mutator) mutateTable() bool {
+	c, ok := m.pickClause()
+	if !ok || c.From == nil {
+		return false
+	}
+	var candidates []*tree.AliasedTableExpr
+	for _, t := range c.From.Tables {
+		for _, a := range aliasedTables(t) {
+			if _, ok := a.Expr.(*tree.TableName); ok {
+				candidates = append(candidates, a)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+	a := candidates[m.rnd.Intn(len(candidates))]
+	tn := a.Expr.(*tree.TableName)
+	from, ok := m.lookupTable(tn)
+	if !ok {
+		return false
+	}
+	to, ok := m.pickTable(func(t table) bool {
+		return t.qualifiedName() != from.qualifiedName() && hasColumns(t, from.cols)
+	})
+	if !ok {
+		return false
+	}
+	if a.As.Alias == "" {
+		a.As.Alias = tn.TableName
+	}
+	newName := tableName(to)
+	a.Expr = &newName
+	return true
+}
+
+// tableName returns the name of t, qualified with its database and schema
+// if we know them, so it can't resolve to a table of the same name
+// elsewhere.
+func tableName(t table) tree.TableName {
+	tn := tree.MakeUnqualifiedTableName(tree.Name(t.name))
+	if t.schema != "" {
+		tn.SchemaName = tree.Name(t.schema)
+		tn.ExplicitSchema = true
+	}
+	if t.catalog != "" {
+		if !tn.ExplicitSchema {
+			tn.SchemaName = tree.PublicSchemaName
+			tn.ExplicitSchema = true
+		}
+		tn.CatalogName = tree.Name(t.catalog)
+		tn.ExplicitCatalog = true
+	}
+	return tn
+}
+
+// hasColumns returns whether t has columns with the names and types of
+// cols.
+func hasColumns(t table, cols []column) bool {
+	for _, c := range cols {
+		tc, ok := t.col(c.name)
+		if !ok || !tc.typ.Equivalent(c.typ) {
+			return false
+		}
+	}
+	return true
+}
+
+// mutatePredicate adds a WHERE clause, removes one, or combines it with
+// another predicate.
+func (m *mutator) mutatePredicate() bool {
+	c, ok := m.pickClause()
+	if !ok {
+		return false
+	}
+	if c.Where != nil && m.coin() {
+		c.Where = nil
+		return true
+	}
+	e, ok := m.clauseScope(c).makeBoolExpr()
+	if !ok {
+		return false
+	}
+	pred, err := toExpr(e)
+	if err != nil {
+		return false
+	}
+	switch {
+	case c.Where == nil:
+		c.Where = &tree.Where{Type: tree.AstWhere, Expr: pred}
+	case m.coin():
+		c.Where.Expr = &tree.AndExpr{Left: c.Where.Expr, Right: pred}
+	default:
+		c.Where.Expr = &tree.OrExpr{Left: c.Where.Expr, Right: pred}
+	}
+	return true
+}
+
+// joinTypes are the types of join a join with an ON condition can be changed
+// to.
+var joinTypes = []string{tree.AstInner, tree.AstLeft, tree.AstRight, tree.AstFull}
+
+// mutateJoin changes the type of a join with an ON condition.
+func (m *mutator) mutateJoin() bool {
+	var candidates []*tree.JoinTableExpr
+	for _, c := range m.selectClauses() {
+		if c.From == nil {
+			continue
+		}
+		for _, t := range c.From.Tables {
+			for _, j := range joins(t) {
+				if _, ok := j.Cond.(*tree.OnJoinCond); ok {
+					candidates = append(candidates, j)
+				}
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+	j := candidates[m.rnd.Intn(len(candidates))]
+	// A join without a type is an inner join.
+	current := j.JoinType
+	if current == "" {
+		current = tree.AstInner
+	}
+	others := without(joinTypes, current)
+	j.JoinType = others[m.rnd.Intn(len(others))]
+	return true
+}
@@ -0,0 +1,151 @@
+package sqlsmith
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+)
+
+func TestNamesSchema(t *testing.T) {
+	public := table{namedRelation: namedRelation{name: "t"}, catalog: "db", schema: "public"}
+	other := table{namedRelation: namedRelation{name: "t"}, catalog: "db", schema: "s"}
+	for _, tc := range []struct {
+		name          string
+		public, other bool
+	}{
+		{name: "t", public: true, other: false},
+		{name: "public.t", public: true, other: false},
+		{name: "s.t", public: false, other: true},
+		{name: "db.t", public: true, other: false},
+		{name: "db.s.t", public: false, other: true},
+		{name: "otherdb.s.t", public: false, other: false},
+	} {
+		stmt, err := parser.ParseOne("SELECT * FROM " + tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from := stmt.AST.(*tree.Select).Select.(*tree.SelectClause).From.Tables[0]
+		tn := aliasedTables(from)[0].Expr.(*tree.TableName)
+		if got := namesSchema(tn, public); got != tc.public {
+			t.Errorf("%s names %s: %t, expected %t", tc.name, public.qualifiedName(), got, tc.public)
+		}
+		if got := namesSchema(tn, other); got != tc.other {
+			t.Errorf("%s names %s: %t, expected %t", tc.name, other.qualifiedName(), got, tc.other)
+		}
+	}
+}
+
+// newTestMutator returns a mutator of stmt, which must parse.
+func newTestMutator(t *testing.T, seed int64, stmt string) *mutator {
+	t.Helper()
+	parsed, err := parser.ParseOne(stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mutator{scope: newTestSmither(t, seed).makeScope(), stmt: parsed.AST}
+}
+
+// reparse checks the mutated statement of m parses, and returns its
+// first SELECT clause.
+func reparse(t *testing.T, m *mutator) *tree.SelectClause {
+	t.Helper()
+	sql := tree.AsString(m.stmt)
+	stmt, err := parser.ParseOne(sql)
+	if err != nil {
+		t.Fatalf("mutation doesn't parse: %v\n%s", err, sql)
+	}
+	return stmt.AST.(*tree.Select).Select.(*tree.SelectClause)
+}
+
+func TestMutateExpr(t *testing.T) {
+	for _, tc := range []struct {
+		stmt string
+		typ  types.T
+	}{
+		{stmt: "SELECT CAST('1' AS INT8)", typ: types.Int},
+		{stmt: "SELECT CAST('a' AS STRING)", typ: types.String},
+		{stmt: "SELECT CAST('1.5' AS DECIMAL)", typ: types.Decimal},
+		{stmt: "SELECT 1 > 0", typ: types.Bool},
+	} {
+		for seed := int64(0); seed < 20; seed++ {
+			m := newTestMutator(t, seed, tc.stmt)
+			// Without a FROM clause or subqueries, the replacement can be
+			// type checked without a database.
+			m.scalarOnly = true
+			if !m.mutateExpr() {
+				continue
+			}
+			expr := reparse(t, m).Exprs[0].Expr
+			typed, err := tree.TypeCheck(expr, &tree.SemaContext{}, tc.typ)
+			if pgErr, ok := err.(*pgerror.Error); ok &&
+				pgErr.Code == pgerror.CodeNumericValueOutOfRangeError {
+				// Constants may overflow when they're folded, which is an
+				// ordinary error.
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, seed %d: %s doesn't type check: %v", tc.stmt, seed, expr, err)
+				continue
+			}
+			if typed != tree.DNull && !typed.ResolvedType().Equivalent(tc.typ) {
+				t.Errorf("%s, seed %d: %s has type %s, expected %s",
+					tc.stmt, seed, expr, typed.ResolvedType(), tc.typ)
+			}
+		}
+	}
+}
+
+func TestMutateTable(t *testing.T) {
+	m := newTestMutator(t, 0, "SELECT id, total FROM big_orders")
+	// orders is the only table with all of big_orders' columns.
+	for i, tab := range m.schema.tables {
+		if tab.name == "orders" {
+			m.schema.tables[i].catalog = "db"
+			m.schema.tables[i].schema = "s"
+		}
+	}
+	if !m.mutateTable() {
+		t.Fatal("expected big_orders to be replaced")
+	}
+	from := reparse(t, m).From.Tables[0].(*tree.AliasedTableExpr)
+	if name := tree.AsString(from.Expr); name != "db.s.orders" {
+		t.Errorf("expected big_orders to be replaced by db.s.orders, got %s", name)
+	}
+	if from.As.Alias != "big_orders" {
+		t.Errorf("expected the table to keep the name big_orders, got %s", from.As.Alias)
+	}
+}
+
+func TestMutatePredicate(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		m := newTestMutator(t, seed, "SELECT 1 WHERE true")
+		m.scalarOnly = true
+		if !m.mutatePredicate() {
+			continue
+		}
+		where := reparse(t, m).Where
+		if where == nil {
+			continue
+		}
+		if _, err := tree.TypeCheck(where.Expr, &tree.SemaContext{}, types.Bool); err != nil {
+			t.Errorf("seed %d: WHERE %s doesn't type check: %v", seed, where.Expr, err)
+		}
+	}
+}
+
+func TestMutateJoin(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		m := newTestMutator(t, seed,
+			"SELECT * FROM customers AS c JOIN orders AS o ON c.id = o.customer")
+		if !m.mutateJoin() {
+			t.Fatalf("seed %d: expected the join to be mutated", seed)
+		}
+		join := reparse(t, m).From.Tables[0].(*tree.JoinTableExpr)
+		if join.JoinType == "" || join.JoinType == tree.AstInner {
+			t.Errorf("seed %d: expected the join to no longer be an inner join: %s", seed, join)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package sqlsmith
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// execution is the outcome of executing a single statement, as written to
+// the output sinks.
+type execution struct {
+	worker int
+	seed   int64
+	// index is the number of statements the worker executed before this one.
+	index int
+	stmt  string
+	// args are the arguments of a prepared statement.
+	args []interface{}
+	// prepare is set if stmt was only prepared, not executed.
+	prepare bool
+	// generated is set if stmt was generated or mutated, rather than
+	// setting up the database or controlling a transaction.
+	generated bool
+	// deterministic is set if stmt was generated and its result only
+	// depends on the data, so would be the same if it were replayed.
+	deterministic bool
+	duration      time.Duration
+	// code is the error code the statement failed with, or empty if it was
+	// successful.
+	code string
+	// rowCount is the number of rows the statement returned.
+	rowCount int
+	// colTypes and rows are the types of the columns of the result and the
+	// result itself, if any sink wants them.
+	colTypes []string
+	rows     [][]string
+}
+
+// sink is a destination for the outcomes of the statements executed during
+// a run. Sinks are shared between workers, so must be safe for concurrent
+// use.
+type sink interface {
+	record(e execution) error
+	// wantsRows returns whether the sink needs the results of statements,
+	// not just their row counts.
+	wantsRows() bool
+	// Flush writes out anything the sink has buffered.
+	Flush() error
+	Close() error
+}
+
+type sinks []sink
+
+func (s sinks) record(e execution) error {
+	for _, k := range s {
+		if err := k.record(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s sinks) wantsRows() bool {
+	for _, k := range s {
+		if k.wantsRows() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s sinks) Flush() error {
+	var result error
+	for _, k := range s {
+		if err := k.Flush(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (s sinks) Close() error {
+	var result error
+	for _, k := range s {
+		if err := k.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// openSinks opens the sinks requested in opts.
+func openSinks(opts Options) (sinks, error) {
+	var result sinks
+	for _, o := range []struct {
+		path string
+		make func(io.WriteCloser) sink
+	}{
+		{opts.JSONFile, newJSONSink},
+		{opts.CorpusFile, newCorpusSink},
+		{opts.LogicTestFile, newLogicTestSink},
+	} {
+		if o.path == "" {
+			continue
+		}
+		f, err := os.Create(o.path)
+		if err != nil {
+			_ = result.Close()
+			return nil, err
+		}
+		result = append(result, o.make(f))
+	}
+	return result, nil
+}
+
+// fileSink serializes writes to a buffered file.
+type fileSink struct {
+	mu sync.Mutex
+	f  io.WriteCloser
+	w  *bufio.Writer
+}
+
+func makeFileSink(f io.WriteCloser) fileSink {
+	return fileSink{f: f, w: bufio.NewWriter(f)}
+}
+
+func (s *fileSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		_ = s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+func (s *fileSink) wantsRows() bool {
+	return false
+}
+
+/////////////
+// JSON LINES
+/////////////
+
+// jsonSink writes each execution as a line of JSON.
+type jsonSink struct {
+	fileSink
+}
+
+func newJSONSink(f io.WriteCloser) sink {
+	return &jsonSink{makeFileSink(f)}
+}
+
+type jsonExecution struct {
+	Worker     int           `json:"worker"`
+	Seed       int64         `json:"seed"`
+	Index      int           `json:"index"`
+	SQL        string        `json:"sql"`
+	Args       []interface{} `json:"args,omitempty"`
+	Prepare    bool          `json:"prepare,omitempty"`
+	DurationMs float64       `json:"duration_ms"`
+	ErrorCode  string        `json:"error_code,omitempty"`
+	Rows       int           `json:"rows"`
+}
+
+func (s *jsonSink) record(e execution) error {
+	line, err := json.Marshal(jsonExecution{
+		Worker:     e.worker,
+		Seed:       e.seed,
+		Index:      e.index,
+		SQL:        e.stmt,
+		Args:       e.args,
+		Prepare:    e.prepare,
+		DurationMs: float64(e.duration) / float64(time.Millisecond),
+		ErrorCode:  e.code,
+		Rows:       e.rowCount,
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(line); err != nil {
+		return err
+	}
+	return s.w.WriteByte('\n')
+}
+
+/////////
+// CORPUS
+/////////
+
+// corpusSink writes every statement which executed successfully, without
+// arguments, to a .sql file. Prepared statements are left out, since they
+// have placeholders.
+type corpusSink struct {
+	fileSink
+}
+
+func newCorpusSink(f io.WriteCloser) sink {
+	return &corpusSink{makeFileSink(f)}
+}
+
+func (s *corpusSink) record(e execution) error {
+	if e.code != "" || e.args != nil || e.prepare {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.w, "%s;\n\n", e.stmt)
+	return err
+}
+
+/////////////
+// LOGIC TEST
+/////////////
+
+// maxLogicTestRows is the largest result written out as a query block.
+// Statements returning more rows are written as statement blocks.
+const maxLogicTestRows = 100
+
+// logicTestSink writes executions in the format of Cockroach's logic tests,
+// with the results the statements returned as the expected results. Since
+// workers execute statements concurrently, the file only replays faithfully
+// if it was written by a single worker. Only the results of generated
+// statements known to be deterministic are written out; the rest, such as
+// mutations or queries calling random(), are only expected to succeed.
+// Prepared statements are left out, since logic tests have no way to bind
+// arguments.
+type logicTestSink struct {
+	fileSink
+}
+
+func newLogicTestSink(f io.WriteCloser) sink {
+	return &logicTestSink{makeFileSink(f)}
+}
+
+func (s *logicTestSink) wantsRows() bool {
+	return true
+}
+
+func (s *logicTestSink) record(e execution) error {
+	if e.args != nil || e.prepare {
+		return nil
+	}
+	// Only record errors which came with an error code, rather than crashes
+	// and hangs.
+	if e.code != "" && (len(e.code) != 5 || e.code == internalErrorCode) {
+		return nil
+	}
+
+	var b strings.Builder
+	switch {
+	case e.code != "":
+		fmt.Fprintf(&b, "statement error pgcode %s\n%s\n\n", e.code, e.stmt)
+	case !e.deterministic || len(e.colTypes) == 0 || e.rows == nil || e.rowCount > maxLogicTestRows:
+		fmt.Fprintf(&b, "statement ok\n%s\n\n", e.stmt)
+	default:
+		fmt.Fprintf(&b, "query %s rowsort\n%s\n----\n", strings.Join(e.colTypes, ""), e.stmt)
+		for _, row := range e.rows {
+			b.WriteString(strings.Join(row, " "))
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.WriteString(b.String())
+	return err
+}
+
+// logicTestType returns the logic test column type for a column of the given
+// database type.
+func logicTestType(dbType string) string {
+	switch dbType {
+	case "INT2", "INT4", "INT8":
+		return "I"
+	case "FLOAT4", "FLOAT8", "NUMERIC":
+		return "R"
+	case "BOOL":
+		return "B"
+	case "OID":
+		return "O"
+	default:
+		return "T"
+	}
+}
+
+// logicTestValue formats a value as it appears in a logic test result.
+func logicTestValue(v sql.NullString) string {
+	switch {
+	case !v.Valid:
+		return "NULL"
+	case v.String == "":
+		return "·"
+	default:
+		return strings.Replace(v.String, "\n", " ", -1)
+	}
+}
+
+// readRows reads the results of a statement into e, keeping the results
+// themselves if keep is set and there aren't too many of them, and checking
+// every row with check if it's set. It closes rows.
+func readRows(rows *sql.Rows, keep bool, check *resultCheck, e *execution) error {
+	defer rows.Close()
+	var dest []interface{}
+	var values []sql.NullString
+	if keep || check != nil {
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			return err
+		}
+		values = make([]sql.NullString, len(types))
+		for i, t := range types {
+			if keep {
+				e.colTypes = append(e.colTypes, logicTestType(t.DatabaseTypeName()))
+			}
+			dest = append(dest, &values[i])
+		}
+		if keep {
+			e.rows = [][]string{}
+		}
+		if check != nil {
+			check.columns(len(types))
+		}
+	}
+	for rows.Next() {
+		e.rowCount++
+		keepRow := keep && e.rowCount <= maxLogicTestRows
+		if !keepRow && check == nil {
+			continue
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if check != nil {
+			check.row(values)
+		}
+		if !keepRow {
+			continue
+		}
+		row := make([]string, len(values))
+		for i, v := range values {
+			row[i] = logicTestValue(v)
+		}
+		e.rows = append(e.rows, row)
+	}
+	return rows.Err()
+}
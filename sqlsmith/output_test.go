@@ -0,0 +1,89 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// bufferCloser is a buffer which can be written to as a file.
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error {
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	executions := []execution{
+		{
+			worker: 1, seed: 2, index: 0, stmt: "select 1", generated: true, deterministic: true,
+			duration: time.Millisecond, rowCount: 1, colTypes: []string{"I"}, rows: [][]string{{"1"}},
+		},
+		{
+			index: 1, stmt: "select random()", generated: true,
+			rowCount: 1, colTypes: []string{"R"}, rows: [][]string{{"0.5"}},
+		},
+		{index: 2, stmt: "select x", generated: true, deterministic: true, code: "42703"},
+		{index: 3, stmt: "select $1", prepare: true},
+		{index: 4, stmt: "select $1", args: []interface{}{int64(1)}, rowCount: 1},
+		{index: 5, stmt: "select crash()", code: "crashed"},
+	}
+	for _, tc := range []struct {
+		name     string
+		make     func(f *bufferCloser) sink
+		expected string
+	}{
+		{
+			name: "json",
+			make: func(f *bufferCloser) sink { return newJSONSink(f) },
+			expected: `{"worker":1,"seed":2,"index":0,"sql":"select 1","duration_ms":1,"rows":1}
+{"worker":0,"seed":0,"index":1,"sql":"select random()","duration_ms":0,"rows":1}
+{"worker":0,"seed":0,"index":2,"sql":"select x","duration_ms":0,"error_code":"42703","rows":0}
+{"worker":0,"seed":0,"index":3,"sql":"select $1","prepare":true,"duration_ms":0,"rows":0}
+{"worker":0,"seed":0,"index":4,"sql":"select $1","args":[1],"duration_ms":0,"rows":1}
+{"worker":0,"seed":0,"index":5,"sql":"select crash()","duration_ms":0,"error_code":"crashed","rows":0}
+`,
+		},
+		{
+			name: "corpus",
+			make: func(f *bufferCloser) sink { return newCorpusSink(f) },
+			expected: `select 1;
+
+select random();
+
+`,
+		},
+		{
+			name: "logic test",
+			make: func(f *bufferCloser) sink { return newLogicTestSink(f) },
+			expected: `query I rowsort
+select 1
+----
+1
+
+statement ok
+select random()
+
+statement error pgcode 42703
+select x
+
+`,
+		},
+	} {
+		f := &bufferCloser{}
+		s := tc.make(f)
+		for _, e := range executions {
+			if err := s.record(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if f.String() != tc.expected {
+			t.Errorf("%s sink wrote:\n%s\nexpected:\n%s", tc.name, f.String(), tc.expected)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+)
+
+// populateBatchSize is the number of rows inserted by each statement when
+// populating a table.
+const populateBatchSize = 100
+
+// populator generates the values of one column of the rows used to populate
+// a table. Each column is given a distribution when the table is populated,
+// so that some columns are full of duplicates or NULLs and others are spread
+// out.
+type populator struct {
+	col  column
+	dist string
+	// pool is the set of values duplicates are drawn from.
+	pool []string
+}
+
+func (s *scope) makePopulator(col column) populator {
+	p := populator{
+		col: col,
+		dist: s.pick(
+			"populate.uniform",
+			"populate.null_heavy",
+			"populate.duplicates",
+			"populate.boundary",
+		),
+	}
+	if p.dist == "populate.duplicates" {
+		for i := s.rnd.Intn(5); i >= 0; i-- {
+			p.pool = append(p.pool, s.randValue(col, 10))
+		}
+	}
+	return p
+}
+
+// randValue returns a random value of col's type, which is NULL with a 1 in
+// nullChance chance if the column is nullable.
+func (s *scope) randValue(col column, nullChance int) string {
+	colTyp, err := sqlbase.DatumTypeToColumnType(col.typ)
+	if err != nil {
+		panic(err)
+	}
+	if !col.nullable {
+		nullChance = 0
+	}
+	return sqlbase.RandDatumWithNullChance(s.rnd, colTyp, nullChance).String()
+}
+
+func (s *scope) makePopulateValue(p populator) string {
+	switch p.dist {
+	case "populate.null_heavy":
+		if p.col.nullable && s.d100() <= 70 {
+			return "NULL"
+		}
+	case "populate.duplicates":
+		return p.pool[s.rnd.Intn(len(p.pool))]
+	case "populate.boundary":
+		if values := boundaryValues(p.col.typ); len(values) > 0 && s.coin() {
+			return values[s.rnd.Intn(len(values))]
+		}
+	}
+	return s.randValue(p.col, 10)
+}
+
+// boundaryValues returns values of typ which are at the edges of its domain,
+// and so are likely to exercise edge cases.
+func boundaryValues(typ types.T) []string {
+	switch typ {
+	case types.Int:
+		return []string{"-9223372036854775808", "9223372036854775807", "0", "-1", "1"}
+	case types.Float:
+		return []string{
+			"'NaN'::FLOAT8", "'+Inf'::FLOAT8", "'-Inf'::FLOAT8", "-0.0::FLOAT8",
+			"1.7976931348623157e308::FLOAT8", "5e-324::FLOAT8",
+		}
+	case types.Decimal:
+		return []string{"'NaN'::DECIMAL", "0::DECIMAL", "-0::DECIMAL", "1e-30::DECIMAL", "9.99999999999999999999e30::DECIMAL"}
+	case types.String:
+		return []string{"''", "' '", "repeat('x', 65536)", "e'\\x00'"}
+	case types.Bytes:
+		return []string{"''::BYTES", "repeat('x', 65536)::BYTES"}
+	case types.Date:
+		return []string{"'4714-11-24 BC'::DATE", "'5874897-12-31'::DATE", "'1970-01-01'::DATE"}
+	case types.Timestamp:
+		return []string{"'4714-11-24 00:00:00 BC'::TIMESTAMP", "'294276-12-31 23:59:59'::TIMESTAMP", "'1970-01-01'::TIMESTAMP"}
+	case types.TimestampTZ:
+		return []string{"'4714-11-24 00:00:00+00 BC'::TIMESTAMPTZ", "'294276-12-31 23:59:59+00'::TIMESTAMPTZ"}
+	case types.Interval:
+		return []string{"'0s'::INTERVAL", "'-178000000 years'::INTERVAL", "'178000000 years'::INTERVAL"}
+	case types.JSON:
+		return []string{"'null'::JSONB", "'{}'::JSONB", "'[]'::JSONB", "'\"\"'::JSONB"}
+	}
+	return nil
+}
+
+// population is the set of statements which populate a table.
+type population struct {
+	// insert is the start of every INSERT, up to and including VALUES.
+	insert string
+	// batches are the rows inserted by each INSERT.
+	batches [][]string
+	// stats creates statistics on the table once it's been populated.
+	stats string
+}
+
+// insertStmt returns the statement which inserts rows. Rows which conflict
+// with existing ones are skipped.
+func (p population) insertStmt(rows []string) string {
+	return p.insert + strings.Join(rows, ", ") + " on conflict do nothing"
+}
+
+// makePopulation generates n rows to insert into t, in batches of
+// populateBatchSize. Computed columns are left to be computed. Rows may well
+// violate the table's check and foreign key constraints, so the caller should
+// retry a failed batch a row at a time to skip them.
+func (s *scope) makePopulation(t table, n int) (population, bool) {
+	var pops []populator
+	var names []string
+	for _, c := range t.cols {
+		if c.writability != writable {
+			continue
+		}
+		pops = append(pops, s.makePopulator(c))
+		names = append(names, tree.NameString(c.name))
+	}
+	if len(pops) == 0 || n <= 0 {
+		return population{}, false
+	}
+	p := population{
+		insert: fmt.Sprintf("insert into %s (%s) values ", t.qualifiedName(), strings.Join(names, ", ")),
+		stats:  fmt.Sprintf("create statistics %s from %s", s.uniqueName("stats"), t.qualifiedName()),
+	}
+
+	var buf bytes.Buffer
+	var batch []string
+	for i := 0; i < n; i++ {
+		buf.Reset()
+		buf.WriteByte('(')
+		for j, pop := range pops {
+			if j > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(s.makePopulateValue(pop))
+		}
+		buf.WriteByte(')')
+		batch = append(batch, buf.String())
+		if len(batch) == populateBatchSize || i == n-1 {
+			p.batches = append(p.batches, batch)
+			batch = nil
+		}
+	}
+	return p, true
+}
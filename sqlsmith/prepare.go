@@ -0,0 +1,105 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/lex"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+)
+
+// placeholderExpr is a placeholder standing in for a constant of type typ.
+type placeholderExpr struct {
+	typ types.T
+	// cast is set if the placeholder is cast to typ, rather than having its
+	// type inferred by the server.
+	cast bool
+	// n is the number of the placeholder, set by numberPlaceholders once the
+	// statement is complete.
+	n int
+}
+
+func (p *placeholderExpr) Type() types.T {
+	return p.typ
+}
+
+func (p *placeholderExpr) Format(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "$%d", p.n)
+	if p.cast {
+		fmt.Fprintf(buf, "::%s", p.typ.SQLName())
+	}
+}
+
+// makePlaceholder returns a placeholder standing in for a constant of type
+// typ. Some are cast to typ, and the rest have their type inferred by the
+// server.
+func (s *scope) makePlaceholder(typ types.T) scalarExpr {
+	return &placeholderExpr{typ: typ, cast: s.chance("prepare.cast")}
+}
+
+// numberPlaceholders numbers the placeholders of expr, which is complete, and
+// returns their types in order. Numbering them as they're generated would
+// leave gaps for those in expressions which were then abandoned.
+func numberPlaceholders(expr relExpr) []types.T {
+	var typs []types.T
+	walk(expr, func(e interface{}) {
+		if p, ok := e.(*placeholderExpr); ok {
+			typs = append(typs, p.typ)
+			p.n = len(typs)
+		}
+	})
+	return typs
+}
+
+// generatePrepared makes a statement in which some constants are replaced by
+// placeholders, returning the types of the placeholders along with it.
+func (s *Smither) generatePrepared() (relExpr, []types.T, bool) {
+	s.schema.RLock()
+	defer s.schema.RUnlock()
+
+	sc := s.makeScope()
+	sc.prepare = true
+	expr, ok := sc.makeStmtOfKind(AnyStatement)
+	if !ok {
+		return nil, nil, false
+	}
+	return expr, numberPlaceholders(expr), true
+}
+
+// placeholderArgs returns random arguments for placeholders of the given
+// types, in the text format the server parses them from.
+func placeholderArgs(rnd *rand.Rand, typs []types.T) []interface{} {
+	args := make([]interface{}, len(typs))
+	for i, typ := range typs {
+		col, err := sqlbase.DatumTypeToColumnType(typ)
+		if err != nil {
+			panic(err)
+		}
+		d := sqlbase.RandDatumWithNullChance(rnd, col, 10)
+		if d == tree.DNull {
+			continue
+		}
+		args[i] = tree.AsStringWithFlags(d, tree.FmtBareStrings)
+	}
+	return args
+}
+
+// formatArgs formats placeholder arguments as a list of string literals, for
+// logging.
+func formatArgs(args []interface{}) string {
+	var buf bytes.Buffer
+	for i, a := range args {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if a == nil {
+			buf.WriteString("NULL")
+			continue
+		}
+		lex.EncodeSQLString(&buf, a.(string))
+	}
+	return buf.String()
+}
@@ -2,26 +2,48 @@ package sqlsmith
 
 import "math/rand"
 
-func coin() bool {
-	return rand.Intn(2) == 0
+func (s *scope) coin() bool {
+	return s.rnd.Intn(2) == 0
 }
 
-func d6() int {
-	return rand.Intn(6) + 1
+func (s *scope) d6() int {
+	return s.rnd.Intn(6) + 1
 }
 
-func d9() int {
-	return rand.Intn(6) + 1
+func (s *scope) d100() int {
+	return s.rnd.Intn(100) + 1
 }
 
-func d20() int {
-	return rand.Intn(20) + 1
+// Every random decision a Smither makes, including those made for it by
+// Cockroach's random datum generation, comes from its *rand.Rand. Building
+// that from a byteSource drives the decisions from a byte slice instead, so
+// a fuzzer can explore and minimize them.
+
+// byteSource is a rand.Source which takes one byte of input for each value it
+// returns. Once the input runs out, it returns zeros, which steers every
+// decision towards its first choice, and so towards small statements.
+type byteSource struct {
+	data []byte
 }
 
-func d42() int {
-	return rand.Intn(42) + 1
+// NewByteSource returns a rand.Source which derives its values from data,
+// for driving a Smither from fuzz input:
+//
+//	smither, err := sqlsmith.NewSmitherFromSnapshot(path, rand.New(sqlsmith.NewByteSource(data)))
+func NewByteSource(data []byte) rand.Source {
+	return &byteSource{data: data}
 }
 
-func d100() int {
-	return rand.Intn(100) + 1
+// Int63 implements rand.Source. The byte is repeated across the value, so
+// that rand.Rand sees it however many bits it uses.
+func (s *byteSource) Int63() int64 {
+	if len(s.data) == 0 {
+		return 0
+	}
+	b := s.data[0]
+	s.data = s.data[1:]
+	return int64(uint64(b) * 0x0101010101010101 >> 1)
 }
+
+// Seed implements rand.Source. The input can't be reseeded.
+func (s *byteSource) Seed(int64) {}
@@ -3,33 +3,61 @@ package sqlsmith
 import (
 	"bytes"
 	"fmt"
-	"math/rand"
 
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
 )
 
 func (s *scope) makeStmt() (*scope, bool) {
-	if d6() < 3 {
-		return s.makeInsert()
+	candidates := s.feasible(types.Any, true, /* deeper */
+		"stmt.returning", "stmt.insert", "stmt.ddl")
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	production := s.pick(candidates...)
+	var outScope *scope
+	var ok bool
+	switch production {
+	case "stmt.insert":
+		outScope, ok = s.makeInsert()
+	case "stmt.ddl":
+		outScope, ok = s.makeDDL()
+	case "stmt.returning":
+		outScope, ok = s.makeReturningStmt(nil)
 	}
-	return s.makeReturningStmt(nil)
+	if !ok {
+		s.coverage.fail(production)
+	}
+	return outScope, ok
 }
 
 func (s *scope) makeReturningStmt(desiredTypes []types.T) (*scope, bool) {
 	for i := 0; i < retryCount; i++ {
+		candidates := s.feasible(types.Any, s.deeper(0),
+			"returning.select", "returning.values", "returning.setop")
+		if len(candidates) == 0 {
+			continue
+		}
 		var outScope *scope
 		var ok bool
-		if s.level < d6() && d6() < 3 {
-			outScope, ok = s.makeValues(desiredTypes)
-			//} else if d6() < 3 {
-			//	outScope, ok = s.makeSetOp(desiredTypes)
-		} else {
+		production := s.pick(candidates...)
+		length := s.spent.length
+		s.spend()
+		switch production {
+		case "returning.select":
 			outScope, ok = s.makeSelect(desiredTypes)
+		case "returning.values":
+			outScope, ok = s.makeValues(desiredTypes)
+		case "returning.setop":
+			outScope, ok = s.makeSetOp(desiredTypes)
 		}
 		if ok {
 			return outScope, true
 		}
+		s.spent.length = length
+		s.coverage.fail(production)
 	}
+	s.coverage.abandon("returning")
 	return nil, false
 }
 
@@ -38,27 +66,86 @@ func (s *scope) getTableExpr() (*scope, bool) {
 		return nil, false
 	}
 	outScope := s.push()
-	table := s.schema.tables[rand.Intn(len(s.schema.tables))]
+	table := s.schema.tables[s.rnd.Intn(len(s.schema.tables))]
+	expr := &tableExpr{
+		rel:       table,
+		alias:     s.name("tab"),
+		indexHint: s.makeIndexHint(table),
+	}
+	outScope.expr = expr
+	outScope.refs = append(outScope.refs, expr)
+	return outScope, true
+}
+
+// makeIndexHint returns the name of an index of t to force, or the empty
+// string if none should be.
+func (s *scope) makeIndexHint(t table) string {
+	if !s.chance("source.index_hint") {
+		return ""
+	}
+	var hintable []string
+	for _, idx := range t.indexes {
+		if idx.hintable() && idx.name != "" {
+			hintable = append(hintable, idx.name)
+		}
+	}
+	if len(hintable) == 0 {
+		return ""
+	}
+	return hintable[s.rnd.Intn(len(hintable))]
+}
+
+// getInsertableTableExpr is like getTableExpr, but only returns tables we can
+// write to.
+func (s *scope) getInsertableTableExpr() (*scope, bool) {
+	var insertable []table
+	for _, t := range s.schema.tables {
+		if t.isInsertable {
+			insertable = append(insertable, t)
+		}
+	}
+	if len(insertable) == 0 {
+		return nil, false
+	}
+	outScope := s.push()
 	outScope.expr = &tableExpr{
-		rel:   table,
+		rel:   insertable[s.rnd.Intn(len(insertable))],
 		alias: s.name("tab"),
 	}
 	return outScope, true
 }
 
 func (s *scope) makeDataSource() (*scope, bool) {
+	if s.overLength() {
+		return nil, false
+	}
 	s = s.push()
-	if s.level < 3+d6() {
-		if d6() > 4 {
-			return s.makeJoinExpr()
-		}
+	candidates := s.feasible(types.Any, s.deeper(3),
+		"source.table", "source.join", "source.insert_returning")
+	if len(candidates) == 0 {
+		return nil, false
 	}
 
-	if s.level < 3+d6() && coin() {
-		return s.makeInsertReturning(nil)
+	production := s.pick(candidates...)
+	length := s.spent.length
+	s.spend()
+	var outScope *scope
+	var ok bool
+	switch production {
+	case "source.join":
+		outScope, ok = s.makeJoinExpr()
+	case "source.insert_returning":
+		outScope, ok = s.pushSubquery().makeInsertReturning(nil)
+	case "source.table":
+		if outScope, ok = s.getTableExpr(); ok {
+			s.spendLeaf(outScope.expr)
+		}
 	}
-
-	return s.getTableExpr()
+	if !ok {
+		s.spent.length = length
+		s.coverage.fail(production)
+	}
+	return outScope, ok
 }
 
 type Format interface {
@@ -91,7 +178,9 @@ type tableRef interface {
 
 type tableExpr struct {
 	alias string
-	rel   namedRelation
+	rel   table
+	// indexHint is the name of the index to force, if any.
+	indexHint string
 }
 
 func (t tableExpr) Name() string {
@@ -99,7 +188,12 @@ func (t tableExpr) Name() string {
 }
 
 func (t tableExpr) Format(buf *bytes.Buffer) {
-	fmt.Fprintf(buf, "%s as %s", t.rel.name, t.alias)
+	buf.WriteString(t.rel.qualifiedName())
+	if t.indexHint != "" {
+		buf.WriteByte('@')
+		buf.WriteString(tree.NameString(t.indexHint))
+	}
+	fmt.Fprintf(buf, " as %s", t.alias)
 }
 
 func (t tableExpr) Cols() []column {
@@ -124,6 +218,7 @@ type join struct {
 // TODO(justin): also do outer joins.
 
 func (s *scope) makeJoinExpr() (*scope, bool) {
+	s.spent.joins++
 	outScope := s.push()
 	leftScope, ok := s.makeDataSource()
 	if !ok {
@@ -149,9 +244,25 @@ func (s *scope) makeJoinExpr() (*scope, bool) {
 
 	outScope.refs = append(outScope.refs, leftScope.refs...)
 	outScope.refs = append(outScope.refs, rightScope.refs...)
-	on, ok := s.makeBoolExpr()
+
+	var on scalarExpr
+	production := s.pick("join.on_fk", "join.on_index", "join.on_expr")
+	switch production {
+	case "join.on_fk":
+		on, ok = s.makeForeignKeyJoinPredicate(lhs, rhs)
+	case "join.on_index":
+		on, ok = s.makeIndexJoinPredicate(lhs, rhs)
+	default:
+		ok = false
+	}
 	if !ok {
-		return nil, false
+		if production != "join.on_expr" {
+			s.coverage.fail(production)
+		}
+		on, ok = s.makeBoolExpr()
+		if !ok {
+			return nil, false
+		}
 	}
 
 	outScope.expr = &join{
@@ -164,6 +275,111 @@ func (s *scope) makeJoinExpr() (*scope, bool) {
 	return outScope, true
 }
 
+// tableExprs returns the tables which are joined together in e.
+func tableExprs(e relExpr) []*tableExpr {
+	switch e := e.(type) {
+	case *tableExpr:
+		return []*tableExpr{e}
+	case *join:
+		return append(tableExprs(e.lhs), tableExprs(e.rhs)...)
+	}
+	return nil
+}
+
+func colEq(l *tableExpr, lc column, r *tableExpr, rc column) scalarExpr {
+	return &opExpr{
+		outTyp: types.Bool,
+		left:   &colRefExpr{ref: l.alias + "." + tree.NameString(lc.name), typ: lc.typ},
+		right:  &colRefExpr{ref: r.alias + "." + tree.NameString(rc.name), typ: rc.typ},
+		op:     "=",
+	}
+}
+
+func and(left, right scalarExpr) scalarExpr {
+	if left == nil {
+		return right
+	}
+	return &opExpr{
+		outTyp: types.Bool,
+		left:   left,
+		right:  right,
+		op:     "and",
+	}
+}
+
+// makeForeignKeyJoinPredicate attempts to join lhs and rhs on the columns of
+// a foreign key from a table in one of them to a table in the other.
+func (s *scope) makeForeignKeyJoinPredicate(lhs, rhs relExpr) (scalarExpr, bool) {
+	var preds []scalarExpr
+	addPreds := func(from, to []*tableExpr) {
+		for _, f := range from {
+			for _, fk := range f.rel.foreignKeys {
+				for _, t := range to {
+					if fk.refTable != t.rel.name || len(fk.cols) != len(fk.refCols) {
+						continue
+					}
+					var pred scalarExpr
+					for i := range fk.cols {
+						fc, ok := f.rel.col(fk.cols[i])
+						if !ok {
+							break
+						}
+						tc, ok := t.rel.col(fk.refCols[i])
+						if !ok {
+							break
+						}
+						pred = and(pred, colEq(f, fc, t, tc))
+					}
+					if pred != nil {
+						preds = append(preds, pred)
+					}
+				}
+			}
+		}
+	}
+	left, right := tableExprs(lhs), tableExprs(rhs)
+	addPreds(left, right)
+	addPreds(right, left)
+	if len(preds) == 0 {
+		return nil, false
+	}
+	return preds[s.rnd.Intn(len(preds))], true
+}
+
+// makeIndexJoinPredicate attempts to join lhs and rhs by equating the
+// leading column of an index of a table on one side with a column of the
+// same type on the other.
+func (s *scope) makeIndexJoinPredicate(lhs, rhs relExpr) (scalarExpr, bool) {
+	var preds []scalarExpr
+	addPreds := func(indexed, other []*tableExpr) {
+		for _, it := range indexed {
+			for _, idx := range it.rel.indexes {
+				if len(idx.cols) == 0 || idx.inverted {
+					continue
+				}
+				ic, ok := it.rel.col(idx.cols[0].name)
+				if !ok {
+					continue
+				}
+				for _, ot := range other {
+					for _, oc := range ot.rel.cols {
+						if oc.typ.Equivalent(ic.typ) {
+							preds = append(preds, colEq(ot, oc, it, ic))
+						}
+					}
+				}
+			}
+		}
+	}
+	left, right := tableExprs(lhs), tableExprs(rhs)
+	addPreds(left, right)
+	addPreds(right, left)
+	if len(preds) == 0 {
+		return nil, false
+	}
+	return preds[s.rnd.Intn(len(preds))], true
+}
+
 func (j *join) Format(buf *bytes.Buffer) {
 	j.lhs.Format(buf)
 	buf.WriteString(" join ")
@@ -253,7 +469,7 @@ func (s *scope) makeSelect(desiredTypes []types.T) (*scope, bool) {
 
 	out.selectList = selectList
 
-	if coin() {
+	if s.chance("select.where") {
 		out.filter, ok = outScope.makeBoolExpr()
 		if !ok {
 			return nil, false
@@ -261,7 +477,7 @@ func (s *scope) makeSelect(desiredTypes []types.T) (*scope, bool) {
 	}
 
 	// TODO: make this error less by not generating constants
-	//for coin() {
+	//for s.coin() {
 	//	expr, ok := outScope.makeScalar(anyType)
 	//	if !ok {
 	//		return nil, false
@@ -269,10 +485,10 @@ func (s *scope) makeSelect(desiredTypes []types.T) (*scope, bool) {
 	//	out.orderBy = append(out.orderBy, expr)
 	//}
 
-	out.distinct = d100() == 1
+	out.distinct = s.chance("select.distinct")
 
-	if d6() > 2 {
-		out.limit = fmt.Sprintf("limit %d", d100())
+	if s.chance("select.limit") {
+		out.limit = fmt.Sprintf("limit %d", s.d100())
 	}
 
 	outScope.expr = &out
@@ -283,8 +499,8 @@ func (s *scope) makeSelect(desiredTypes []types.T) (*scope, bool) {
 func (s *scope) makeSelectList(desiredTypes []types.T) ([]scalarExpr, bool) {
 	if desiredTypes == nil {
 		for {
-			desiredTypes = append(desiredTypes, getRandType())
-			if d6() == 1 {
+			desiredTypes = append(desiredTypes, s.randType())
+			if s.d6() == 1 {
 				break
 			}
 		}
@@ -309,9 +525,71 @@ func (s *selectExpr) Cols() []column {
 /////////
 
 type insert struct {
-	target  string
-	targets []column
-	input   relExpr
+	target     string
+	targets    []column
+	input      relExpr
+	onConflict *onConflict
+}
+
+// onConflict is the ON CONFLICT clause of an INSERT.
+type onConflict struct {
+	// cols are the columns of the unique index which is the conflict target.
+	cols []string
+	// update are the columns set to their excluded values on conflict. If
+	// there are none, we DO NOTHING.
+	update []column
+}
+
+func (o *onConflict) Format(buf *bytes.Buffer) {
+	buf.WriteString(" on conflict (")
+	for i, c := range o.cols {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(tree.NameString(c))
+	}
+	buf.WriteString(")")
+	if len(o.update) == 0 {
+		buf.WriteString(" do nothing")
+		return
+	}
+	buf.WriteString(" do update set ")
+	comma := ""
+	for _, c := range o.update {
+		buf.WriteString(comma)
+		name := tree.NameString(c.name)
+		fmt.Fprintf(buf, "%s = excluded.%s", name, name)
+		comma = ", "
+	}
+}
+
+// makeOnConflict returns an ON CONFLICT clause for an INSERT writing
+// targets into t, or nil if t has no unique indexes to use as a conflict
+// target.
+func (s *scope) makeOnConflict(t table, targets []column) *onConflict {
+	var unique []index
+	for _, idx := range t.indexes {
+		if idx.unique && !idx.inverted && idx.predicate == "" {
+			unique = append(unique, idx)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+	idx := unique[s.rnd.Intn(len(unique))]
+	out := &onConflict{cols: idx.colNames()}
+	if s.chance("insert.on_conflict_update") {
+		inTarget := make(map[string]bool, len(out.cols))
+		for _, c := range out.cols {
+			inTarget[c] = true
+		}
+		for _, c := range targets {
+			if !inTarget[c.name] {
+				out.update = append(out.update, c)
+			}
+		}
+	}
+	return out
 }
 
 func (i *insert) Format(buf *bytes.Buffer) {
@@ -321,16 +599,19 @@ func (i *insert) Format(buf *bytes.Buffer) {
 	comma := ""
 	for _, c := range i.targets {
 		buf.WriteString(comma)
-		buf.WriteString(c.name)
+		buf.WriteString(tree.NameString(c.name))
 		comma = ", "
 	}
 	buf.WriteString(") ")
 	i.input.Format(buf)
+	if i.onConflict != nil {
+		i.onConflict.Format(buf)
+	}
 }
 
 func (s *scope) makeInsert() (*scope, bool) {
 	outScope := s.push()
-	out, ok := s.getTableExpr()
+	out, ok := s.getInsertableTableExpr()
 	if !ok {
 		return nil, false
 	}
@@ -344,7 +625,7 @@ func (s *scope) makeInsert() (*scope, bool) {
 	for _, c := range target.Cols() {
 		// We *must* write a column if it's writable and non-nullable.
 		// We *can* write a column if it's writable and nullable.
-		if c.writability == writable && (!c.nullable || coin()) {
+		if c.writability == writable && (!c.nullable || s.chance("insert.nullable")) {
 			targets = append(targets, c)
 			desiredTypes = append(desiredTypes, c.typ)
 		}
@@ -362,10 +643,16 @@ func (s *scope) makeInsert() (*scope, bool) {
 		rel:   target.rel,
 	})
 
+	var conflict *onConflict
+	if s.chance("insert.on_conflict") {
+		conflict = s.makeOnConflict(target.rel, targets)
+	}
+
 	outScope.expr = &insert{
-		target:  target.rel.name,
-		targets: targets,
-		input:   input.expr,
+		target:     target.rel.qualifiedName(),
+		targets:    targets,
+		input:      input.expr,
+		onConflict: conflict,
 	}
 
 	return outScope, true
@@ -391,8 +678,8 @@ type insertReturning struct {
 func (s *scope) makeInsertReturning(desiredTypes []types.T) (*scope, bool) {
 	if desiredTypes == nil {
 		for {
-			desiredTypes = append(desiredTypes, getRandType())
-			if d6() < 2 {
+			desiredTypes = append(desiredTypes, s.randType())
+			if s.d6() < 2 {
 				break
 			}
 		}
@@ -446,14 +733,14 @@ func (s *scope) makeValues(desiredTypes []types.T) (*scope, bool) {
 	outScope := s.push()
 	if desiredTypes == nil {
 		for {
-			desiredTypes = append(desiredTypes, getRandType())
-			if d6() < 2 {
+			desiredTypes = append(desiredTypes, s.randType())
+			if s.d6() < 2 {
 				break
 			}
 		}
 	}
 
-	numRowsToInsert := d6()
+	numRowsToInsert := s.d6()
 	vals := make([][]scalarExpr, numRowsToInsert)
 	for i := 0; i < numRowsToInsert; i++ {
 		tuple := make([]scalarExpr, len(desiredTypes))
@@ -508,8 +795,8 @@ func (s *scope) makeSetOp(desiredTypes []types.T) (*scope, bool) {
 	outScope := s.push()
 	if desiredTypes == nil {
 		for {
-			desiredTypes = append(desiredTypes, getRandType())
-			if d6() < 2 {
+			desiredTypes = append(desiredTypes, s.randType())
+			if s.d6() < 2 {
 				break
 			}
 		}
@@ -526,7 +813,7 @@ func (s *scope) makeSetOp(desiredTypes []types.T) (*scope, bool) {
 	}
 
 	outScope.expr = &setOp{
-		op:    setOps[rand.Intn(len(setOps))],
+		op:    setOps[s.rnd.Intn(len(setOps))],
 		left:  leftScope.expr,
 		right: rightScope.expr,
 	}
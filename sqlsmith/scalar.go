@@ -2,8 +2,8 @@ package sqlsmith
 
 import (
 	"bytes"
-	"math/rand"
 
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
 )
@@ -11,38 +11,71 @@ import (
 // makeScalar attempts constructs a scalar expression of the requested type.
 // If it was unsuccessful, it will return false.
 func (s *scope) makeScalar(typ types.T) (scalarExpr, bool) {
+	if s.overLength() {
+		return nil, false
+	}
 	pickedType := typ
 	if typ == types.Any {
-		pickedType = getRandType()
+		pickedType = s.randType()
 	}
 	s = s.push()
 
+	// failed are the non-recursive productions which have failed, and so
+	// would fail again.
+	var failed []string
 	for i := 0; i < retryCount; i++ {
+		deeper := s.deeper(0)
+		candidates := s.feasible(typ, deeper,
+			"scalar.const", "scalar.colref", "scalar.sequence", "scalar.case",
+			"scalar.coalesce", "scalar.binop", "scalar.func", "scalar.subquery")
+		for _, f := range failed {
+			candidates = without(candidates, f)
+		}
+		if len(candidates) == 0 {
+			if deeper {
+				break
+			}
+			continue
+		}
+
 		var result scalarExpr
 		var ok bool
-		// TODO(justin): this is how sqlsmith chooses what to do, but it feels
-		// to me like there should be a more clean/principled approach here.
-		if s.level < d6() && d9() == 1 {
+		production := s.pick(candidates...)
+		length := s.spent.length
+		s.spend()
+		switch production {
+		case "scalar.case":
 			result, ok = s.makeCaseExpr(pickedType)
-		} else if s.level < d6() && d42() == 1 {
+		case "scalar.coalesce":
 			result, ok = s.makeCoalesceExpr(pickedType)
-		} else if len(s.refs) > 0 && d20() > 1 {
+		case "scalar.colref":
 			result, ok = s.makeColRef(typ)
-		} else if s.level < d6() && d9() == 1 {
+		case "scalar.binop":
 			result, ok = s.makeBinOp(typ)
-		} else if s.level < d6() && d9() == 1 {
+		case "scalar.func":
 			result, ok = s.makeFunc(typ)
-		} else if s.level < d6() && d6() == 1 {
+		case "scalar.subquery":
 			result, ok = s.makeScalarSubquery(typ)
-		} else {
+		case "scalar.sequence":
+			result, ok = s.makeSequenceExpr()
+		case "scalar.const":
 			result, ok = s.makeConstExpr(pickedType), true
 		}
 		if ok {
+			if !feasibilities[production].recursive {
+				s.spendLeaf(result)
+			}
 			return result, ok
 		}
+		s.spent.length = length
+		s.coverage.fail(production)
+		if !feasibilities[production].recursive {
+			failed = append(failed, production)
+		}
 	}
 
-	// Retried enough times, give up.
+	// Retried enough times, or nothing is feasible, give up.
+	s.coverage.abandon("scalar")
 	return nil, false
 }
 
@@ -55,20 +88,29 @@ func (s *scope) makeBoolExpr() (scalarExpr, bool) {
 		var result scalarExpr
 		var ok bool
 
-		if d6() < 4 {
+		candidates := s.feasible(types.Bool, true, /* deeper */
+			"bool.binop", "bool.scalar", "bool.exists")
+		production := s.pick(candidates...)
+		length := s.spent.length
+		s.spend()
+		switch production {
+		case "bool.binop":
 			result, ok = s.makeBinOp(types.Bool)
-		} else if d6() < 4 {
+		case "bool.scalar":
 			result, ok = s.makeScalar(types.Bool)
-		} else {
+		case "bool.exists":
 			result, ok = s.makeExists()
 		}
 
 		if ok {
 			return result, ok
 		}
+		s.spent.length = length
+		s.coverage.fail(production)
 	}
 
 	// Retried enough times, give up.
+	s.coverage.abandon("bool")
 	return nil, false
 }
 
@@ -170,12 +212,16 @@ func (c *constExpr) Format(buf *bytes.Buffer) {
 }
 
 func (s *scope) makeConstExpr(typ types.T) scalarExpr {
+	if s.prepare && s.chance("prepare.placeholder") {
+		return s.makePlaceholder(typ)
+	}
+
 	col, err := sqlbase.DatumTypeToColumnType(typ)
 	if err != nil {
 		panic(err)
 	}
 
-	datum := sqlbase.RandDatumWithNullChance(s.schema.rnd, col, 6)
+	datum := sqlbase.RandDatumWithNullChance(s.rnd, col, 6)
 
 	// TODO(justin): maintain context and see if we're in an INSERT, and maybe use
 	// DEFAULT (which is a legal "value" in such a context).
@@ -200,17 +246,28 @@ func (c *colRefExpr) Format(buf *bytes.Buffer) {
 	buf.WriteString(c.ref)
 }
 
+// makeColRef references a column of type typ. If there are none, a column
+// of a type which can be cast to typ is referenced and cast.
 func (s *scope) makeColRef(typ types.T) (scalarExpr, bool) {
-	ref := s.refs[rand.Intn(len(s.refs))]
-	col := ref.Cols()[rand.Intn(len(ref.Cols()))]
-	if typ != types.Any && col.typ != typ {
+	cols, cast := s.columns().candidates(typ)
+	if len(cols) == 0 {
 		return nil, false
 	}
+	sc := cols[s.rnd.Intn(len(cols))]
 
-	return &colRefExpr{
-		ref: ref.Name() + "." + col.name,
-		typ: col.typ,
-	}, true
+	// Refs with no name are referenced unqualified.
+	name := tree.NameString(sc.col.name)
+	if sc.ref.Name() != "" {
+		name = tree.NameString(sc.ref.Name()) + "." + name
+	}
+	var ref scalarExpr = &colRefExpr{
+		ref: name,
+		typ: sc.col.typ,
+	}
+	if cast {
+		ref = &castExpr{typ: typ, expr: ref}
+	}
+	return ref, true
 }
 
 /////////
@@ -223,6 +280,8 @@ type opExpr struct {
 	left  scalarExpr
 	right scalarExpr
 	op    string
+	// overload is the operator overload used, for coverage.
+	overload string
 }
 
 func (o *opExpr) Type() types.T {
@@ -241,13 +300,13 @@ func (o *opExpr) Format(buf *bytes.Buffer) {
 
 func (s *scope) makeBinOp(typ types.T) (scalarExpr, bool) {
 	if typ == types.Any {
-		typ = getRandType()
+		typ = s.randType()
 	}
 	ops := s.schema.GetOperatorsByOutputType(typ)
 	if len(ops) == 0 {
 		return nil, false
 	}
-	op := ops[rand.Intn(len(ops))]
+	op := ops[s.rnd.Intn(len(ops))]
 
 	left, ok := s.makeScalar(op.left)
 	if !ok {
@@ -257,12 +316,12 @@ func (s *scope) makeBinOp(typ types.T) (scalarExpr, bool) {
 	if !ok {
 		return nil, false
 	}
-
 	return &opExpr{
-		outTyp: typ,
-		left:   left,
-		right:  right,
-		op:     op.name,
+		outTyp:   typ,
+		left:     left,
+		right:    right,
+		op:       op.name,
+		overload: op.String(),
 	}, true
 }
 
@@ -275,6 +334,8 @@ type funcExpr struct {
 
 	name   string
 	inputs []scalarExpr
+	// overload is the function overload used, for coverage.
+	overload string
 }
 
 func (f *funcExpr) Type() types.T {
@@ -295,30 +356,100 @@ func (f *funcExpr) Format(buf *bytes.Buffer) {
 
 func (s *scope) makeFunc(typ types.T) (scalarExpr, bool) {
 	if typ == types.Any {
-		typ = getRandType()
+		typ = s.randType()
 	}
 	ops := s.schema.GetFunctionsByOutputType(typ)
 	if len(ops) == 0 {
 		return nil, false
 	}
-	op := ops[rand.Intn(len(ops))]
-
-	args := make([]scalarExpr, 0)
-	for i := range op.inputs {
-		in, ok := s.makeScalar(op.inputs[i])
+	op := ops[s.rnd.Intn(len(ops))]
+
+	// Bind anyelement to the type the result needs to be, if the result is
+	// polymorphic, or to a random type otherwise.
+	var elem types.T
+	switch op.out {
+	case types.Any, anyPseudoType:
+		elem = typ
+	case types.AnyArray:
+		arr, ok := typ.(types.TArray)
 		if !ok {
 			return nil, false
 		}
-		args = append(args, in)
+		elem = arr.Typ
+	default:
+		elem = s.randType()
+	}
+
+	// Leave out some of the arguments with defaults, and pass some number of
+	// variadic arguments. As in Postgres, there must be at least one: some,
+	// like greatest, don't even parse without.
+	n := len(op.inputs) - s.rnd.Intn(op.defaults+1)
+	inputs := append([]types.T(nil), op.inputs[:n]...)
+	if op.variadic != nil {
+		for i := 1 + s.rnd.Intn(3); i > 0; i-- {
+			inputs = append(inputs, op.variadic)
+		}
 	}
 
+	args := make([]scalarExpr, 0, len(inputs))
+	for _, in := range inputs {
+		// Not every type can be an array's element, JSON for one.
+		if valid, _ := types.IsValidArrayElementType(elem); in == types.AnyArray && !valid {
+			return nil, false
+		}
+		argTyp := s.instantiate(in, elem)
+		arg, ok := s.makeScalar(argTyp)
+		if !ok {
+			return nil, false
+		}
+		// Cast arguments which would otherwise leave the call ambiguous.
+		if op.overloaded || isPolymorphic(in) {
+			arg = &castExpr{typ: argTyp, expr: arg}
+		}
+		args = append(args, arg)
+	}
 	return &funcExpr{
-		outTyp: typ,
-		name:   op.name,
-		inputs: args,
+		outTyp:   typ,
+		name:     op.name,
+		inputs:   args,
+		overload: op.String(),
 	}, true
 }
 
+// instantiate returns the concrete type of an argument of type typ, where
+// anyelement is bound to elem. Each "any" argument gets a type of its own.
+func (s *scope) instantiate(typ, elem types.T) types.T {
+	switch typ {
+	case types.Any:
+		return elem
+	case types.AnyArray:
+		return types.TArray{Typ: elem}
+	case anyPseudoType:
+		return s.randType()
+	}
+	return typ
+}
+
+///////
+// CAST
+///////
+
+type castExpr struct {
+	typ  types.T
+	expr scalarExpr
+}
+
+func (c *castExpr) Type() types.T {
+	return c.typ
+}
+
+func (c *castExpr) Format(buf *bytes.Buffer) {
+	buf.WriteByte('(')
+	c.expr.Format(buf)
+	buf.WriteString(")::")
+	buf.WriteString(c.typ.SQLName())
+}
+
 /////////
 // EXISTS
 /////////
@@ -338,7 +469,7 @@ func (e *exists) Type() types.T {
 }
 
 func (s *scope) makeExists() (scalarExpr, bool) {
-	outScope, ok := s.makeSelect(nil)
+	outScope, ok := s.pushSubquery().makeSelect(nil)
 	if !ok {
 		return nil, false
 	}
@@ -352,6 +483,9 @@ func (s *scope) makeExists() (scalarExpr, bool) {
 
 type scalarSubq struct {
 	subquery relExpr
+	// limited is set if the subquery was given LIMIT 1, so it can't return
+	// more than one row.
+	limited bool
 }
 
 func (s *scalarSubq) Format(buf *bytes.Buffer) {
@@ -365,11 +499,17 @@ func (s *scalarSubq) Type() types.T {
 }
 
 func (s *scope) makeScalarSubquery(typ types.T) (scalarExpr, bool) {
-	outScope, ok := s.makeSelect([]types.T{typ})
+	outScope, ok := s.pushSubquery().makeSelect([]types.T{typ})
 	if !ok {
 		return nil, false
 	}
 
-	outScope.expr.(*selectExpr).limit = "limit 1"
-	return &scalarSubq{outScope.expr}, true
+	// Without LIMIT 1 the statement fails if the subquery returns more than
+	// one row, and if it doesn't the subquery may be checked on its own.
+	sub := &scalarSubq{subquery: outScope.expr}
+	if s.chance("scalar.subquery_limit") {
+		outScope.expr.(*selectExpr).limit = "limit 1"
+		sub.limited = true
+	}
+	return sub, true
 }
@@ -2,9 +2,14 @@ package sqlsmith
 
 import (
 	"database/sql"
-	"math/rand"
-	"time"
+	"fmt"
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
 	"github.com/lib/pq"
 	"github.com/lib/pq/oid"
@@ -17,72 +22,188 @@ type operator struct {
 	out   types.T
 }
 
+// function is an overload of a function. Its inputs and output may be
+// polymorphic, in which case they're instantiated when a call is generated.
 type function struct {
 	name   string
 	inputs []types.T
 	out    types.T
+	// variadic, if set, is the type of any number of arguments which may
+	// follow inputs.
+	variadic types.T
+	// defaults is the number of trailing inputs which have default values,
+	// and so may be left out.
+	defaults int
+	// overloaded is set if there are other overloads with the same name,
+	// in which case calls cast their arguments so the right one is picked.
+	overloaded bool
 }
 
 // schema represents the state of the database as sqlsmith-go understands it, including
 // not only the tables present but also things like what operator overloads exist.
+//
+// A schema is shared between workers. ReloadSchemas replaces its contents
+// while holding the write lock, so anything generating from it must hold the
+// read lock.
 type schema struct {
-	db        *sql.DB
-	rnd       *rand.Rand
-	tables    []namedRelation
+	sync.RWMutex
+
+	db *sql.DB
+
+	// databases and schemas are the databases and schemas tables are loaded
+	// from. If empty, they default to the current database and the public
+	// schema. The virtual schemas, like pg_catalog, can be included, and their
+	// tables are read-only.
+	databases []string
+	schemas   []string
+
+	tables    []table
+	sequences []sequence
 	operators map[oid.Oid][]operator
 	functions map[oid.Oid][]function
 }
 
-func (s *schema) makeScope() *scope {
-	return &scope{
-		namer:  &namer{make(map[string]int)},
-		schema: s,
-	}
-}
-
 func (s *schema) GetOperatorsByOutputType(outTyp types.T) []operator {
 	return s.operators[outTyp.Oid()]
 }
 
+// GetFunctionsByOutputType returns the functions which can return outTyp,
+// including polymorphic ones which can be instantiated to return it.
 func (s *schema) GetFunctionsByOutputType(outTyp types.T) []function {
-	return s.functions[outTyp.Oid()]
+	fns := s.functions[outTyp.Oid()]
+	if outTyp.Oid() == types.Any.Oid() {
+		return fns
+	}
+	result := append(fns[:len(fns):len(fns)], s.functions[types.Any.Oid()]...)
+	if _, ok := outTyp.(types.TArray); ok && outTyp.Oid() != types.AnyArray.Oid() {
+		result = append(result, s.functions[types.AnyArray.Oid()]...)
+	}
+	return result
 }
 
-func makeSchema(db *sql.DB) *schema {
+func makeSchema(db *sql.DB, databases, schemas []string) (*schema, error) {
 	s := &schema{
-		db:  db,
-		rnd: rand.New(rand.NewSource(int64(time.Now().Nanosecond()))),
+		db:        db,
+		databases: databases,
+		schemas:   schemas,
+	}
+	if err := s.ReloadSchemas(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *schema) ReloadSchemas() error {
+	// Do the extraction outside the lock so workers aren't blocked on it.
+	tables, err := s.extractTables()
+	if err != nil {
+		return err
+	}
+	sequences, err := s.extractSequences()
+	if err != nil {
+		return err
+	}
+	operators, err := s.extractOperators()
+	if err != nil {
+		return err
+	}
+	functions, err := s.extractFunctions()
+	if err != nil {
+		return err
 	}
-	s.ReloadSchemas()
-	return s
+
+	s.Lock()
+	defer s.Unlock()
+	s.tables = tables
+	s.sequences = sequences
+	s.operators = operators
+	s.functions = functions
+	return nil
 }
 
-func (s *schema) ReloadSchemas() {
-	s.tables = s.extractTables()
-	s.operators = s.extractOperators()
-	s.functions = s.extractFunctions()
+// populatable returns the tables which can be populated with data, which are
+// those named names, or every one if names is nil.
+func (s *schema) populatable(names map[string]bool) []table {
+	s.RLock()
+	defer s.RUnlock()
+	var result []table
+	for _, t := range s.tables {
+		if !t.isBaseTable || !t.isInsertable {
+			continue
+		}
+		if names != nil && !names[t.name] {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result
 }
 
-func (s *schema) extractTables() []namedRelation {
-	rows, err := s.db.Query(`
+// databaseNames returns the databases to load from, where the empty string
+// means the current database.
+func (s *schema) databaseNames() []string {
+	if len(s.databases) == 0 {
+		return []string{""}
+	}
+	return s.databases
+}
+
+func (s *schema) schemaNames() []string {
+	if len(s.schemas) == 0 {
+		return []string{"public"}
+	}
+	return s.schemas
+}
+
+// inDatabase returns the name of the virtual schema vs in database db, or in
+// the current database if db is empty.
+func inDatabase(db, vs string) string {
+	if db == "" {
+		return vs
+	}
+	return tree.NameString(db) + "." + vs
+}
+
+func (s *schema) extractTables() ([]table, error) {
+	databases := s.databaseNames()
+	var tables []table
+	for _, db := range databases {
+		dbTables, err := s.extractTablesFromDatabase(db)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, dbTables...)
+	}
+	return tables, nil
+}
+
+// extractTablesFromDatabase loads the tables in the schemas we're interested
+// in from database db, or the current database if db is empty.
+func (s *schema) extractTablesFromDatabase(db string) ([]table, error) {
+	infoSchema := inDatabase(db, "information_schema")
+	schemas := s.schemaNames()
+	rows, err := s.db.Query(fmt.Sprintf(`
 	SELECT
-		table_catalog,
-		table_schema,
-		table_name,
-		column_name,
-		crdb_sql_type,
-		generation_expression != '' AS computed,
-		is_nullable = 'YES' AS nullable
+		c.table_catalog,
+		c.table_schema,
+		c.table_name,
+		c.column_name,
+		c.crdb_sql_type,
+		c.generation_expression != '' AS computed,
+		c.is_nullable = 'YES' AS nullable,
+		t.table_type = 'BASE TABLE' AS base_table,
+		t.table_type = 'VIEW' AS view,
+		t.is_insertable_into = 'YES' AS insertable
 	FROM
-		information_schema.columns
+		%[1]s.columns AS c
+		JOIN %[1]s.tables AS t USING (table_catalog, table_schema, table_name)
 	WHERE
-		table_schema = 'public'
+		c.table_schema = ANY ($1)
 	ORDER BY
-		table_catalog, table_schema, table_name
-	`)
-	// TODO(justin): have a flag that includes system tables?
+		c.table_catalog, c.table_schema, c.table_name, c.ordinal_position
+	`, infoSchema), pq.Array(schemas))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -92,18 +213,31 @@ func (s *schema) extractTables() []namedRelation {
 
 	firstTime := true
 	var lastCatalog, lastSchema, lastName string
-	var tables []namedRelation
+	var lastBaseTable, lastView, lastInsertable bool
+	var tables []table
 	var currentCols []column
 	emit := func() {
-		tables = append(tables, namedRelation{
-			cols: currentCols,
-			name: lastName,
+		// Virtual tables may have columns whose types we don't know about, and
+		// we skip those. Skip the table too if that left it with no columns.
+		if len(currentCols) == 0 {
+			return
+		}
+		tables = append(tables, table{
+			namedRelation: namedRelation{
+				cols: currentCols,
+				name: lastName,
+			},
+			catalog:      lastCatalog,
+			schema:       lastSchema,
+			isBaseTable:  lastBaseTable,
+			isView:       lastView,
+			isInsertable: lastBaseTable && lastInsertable,
 		})
 	}
 	for rows.Next() {
 		var catalog, schema, name, col, typ string
-		var computed, nullable bool
-		rows.Scan(&catalog, &schema, &name, &col, &typ, &computed, &nullable)
+		var computed, nullable, baseTable, view, insertable bool
+		rows.Scan(&catalog, &schema, &name, &col, &typ, &computed, &nullable, &baseTable, &view, &insertable)
 
 		if firstTime {
 			lastCatalog = catalog
@@ -116,6 +250,17 @@ func (s *schema) extractTables() []namedRelation {
 			emit()
 			currentCols = nil
 		}
+		lastCatalog = catalog
+		lastSchema = schema
+		lastName = name
+		lastBaseTable = baseTable
+		lastView = view
+		lastInsertable = insertable
+
+		colTyp, ok := lookupType(typ)
+		if !ok {
+			continue
+		}
 
 		writability := writable
 		if computed {
@@ -126,22 +271,97 @@ func (s *schema) extractTables() []namedRelation {
 			currentCols,
 			column{
 				name:        col,
-				typ:         typeFromName(typ),
+				typ:         colTyp,
 				nullable:    nullable,
 				writability: writability,
 			},
 		)
-		lastCatalog = catalog
-		lastSchema = schema
-		lastName = name
 	}
 	if !firstTime {
 		emit()
 	}
-	return tables
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.extractConstraints(db, schemas, tables); err != nil {
+		return nil, err
+	}
+	if err := s.extractMaterializedViews(db, schemas, tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
 }
 
-func (s *schema) extractOperators() map[oid.Oid][]operator {
+// extractMaterializedViews marks the views in tables, which were all loaded
+// from database db, which are materialized.
+func (s *schema) extractMaterializedViews(db string, schemas []string, tables []table) error {
+	pgCatalog := inDatabase(db, "pg_catalog")
+	rows, err := s.db.Query(fmt.Sprintf(`
+	SELECT
+		schemaname, matviewname
+	FROM
+		%s.pg_matviews
+	WHERE
+		schemaname = ANY ($1)
+	`, pgCatalog), pq.Array(schemas))
+	if err != nil {
+		if errorCode(err) == undefinedTableCode {
+			// This server doesn't support materialized views.
+			return nil
+		}
+		return err
+	}
+	defer rows.Close()
+	materialized := make(map[tableKey]bool)
+	for rows.Next() {
+		var schemaName, name string
+		rows.Scan(&schemaName, &name)
+		materialized[tableKey{schemaName, name}] = true
+	}
+	for i := range tables {
+		t := &tables[i]
+		if materialized[tableKey{t.schema, t.name}] {
+			t.isView = true
+			t.isMaterialized = true
+		}
+	}
+	return rows.Err()
+}
+
+func (s *schema) extractSequences() ([]sequence, error) {
+	databases := s.databaseNames()
+	schemas := s.schemaNames()
+	var sequences []sequence
+	for _, db := range databases {
+		infoSchema := inDatabase(db, "information_schema")
+		rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT
+			sequence_catalog, sequence_schema, sequence_name
+		FROM
+			%s.sequences
+		WHERE
+			sequence_schema = ANY ($1)
+		ORDER BY
+			sequence_catalog, sequence_schema, sequence_name
+		`, infoSchema), pq.Array(schemas))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var seq sequence
+			rows.Scan(&seq.catalog, &seq.schema, &seq.name)
+			sequences = append(sequences, seq)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sequences, nil
+}
+
+func (s *schema) extractOperators() (map[oid.Oid][]operator, error) {
 	rows, err := s.db.Query(`
 SELECT
 	oprname, oprleft, oprright, oprresult
@@ -151,7 +371,7 @@ WHERE
 	0 NOT IN (oprresult, oprright, oprleft)
 `)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -160,35 +380,40 @@ WHERE
 		var name string
 		var left, right, out oid.Oid
 		rows.Scan(&name, &left, &right, &out)
-		leftTyp, ok := types.OidToType[left]
-		if !ok {
-			continue
-		}
-		rightTyp, ok := types.OidToType[right]
-		if !ok {
-			continue
+		if op, ok := makeOperator(name, left, right, out); ok {
+			result[out] = append(result[out], op)
 		}
-		outTyp, ok := types.OidToType[out]
-		if !ok {
-			continue
-		}
-		result[out] = append(
-			result[out],
-			operator{
-				name:  name,
-				left:  leftTyp,
-				right: rightTyp,
-				out:   outTyp,
-			},
-		)
 	}
-	return result
+	return result, rows.Err()
+}
+
+// makeOperator makes the operator described by a row of pg_operator, if we
+// know all its types.
+func makeOperator(name string, left, right, out oid.Oid) (operator, bool) {
+	leftTyp, ok := knownType(left)
+	if !ok {
+		return operator{}, false
+	}
+	rightTyp, ok := knownType(right)
+	if !ok {
+		return operator{}, false
+	}
+	outTyp, ok := knownType(out)
+	if !ok {
+		return operator{}, false
+	}
+	return operator{
+		name:  name,
+		left:  leftTyp,
+		right: rightTyp,
+		out:   outTyp,
+	}, true
 }
 
-func (s *schema) extractFunctions() map[oid.Oid][]function {
+func (s *schema) extractFunctions() (map[oid.Oid][]function, error) {
 	rows, err := s.db.Query(`
 SELECT
-	proname, proargtypes::INT[], prorettype
+	proname, proargtypes::INT[], prorettype, provariadic, pronargdefaults
 FROM
 	pg_catalog.pg_proc
 WHERE
@@ -198,7 +423,7 @@ WHERE
 	AND proname NOT IN ('crdb_internal.force_panic', 'crdb_internal.force_log_fatal')
 `)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -206,34 +431,151 @@ WHERE
 	for rows.Next() {
 		var name string
 		var inputs []oid.Oid
-		var returnType oid.Oid
-		rows.Scan(&name, pq.Array(&inputs), &returnType)
-
-		typs := make([]types.T, len(inputs))
-		unsupported := false
-		for i, oid := range inputs {
-			t, ok := types.OidToType[oid]
-			if !ok {
-				unsupported = true
-				break
-			}
-			typs[i] = t
+		var returnType, variadic oid.Oid
+		var defaults int
+		rows.Scan(&name, pq.Array(&inputs), &returnType, &variadic, &defaults)
+		if fn, ok := makeFunction(name, inputs, returnType, variadic, defaults); ok {
+			result[fn.out.Oid()] = append(result[fn.out.Oid()], fn)
 		}
+	}
+	markOverloaded(result)
+	return result, rows.Err()
+}
 
-		if unsupported {
-			continue
+// makeFunction makes the function described by a row of pg_proc, if we know
+// all its types.
+func makeFunction(
+	name string, inputs []oid.Oid, returnType, variadic oid.Oid, defaults int,
+) (function, bool) {
+	fn := function{name: name, defaults: defaults}
+	// The variadic argument comes last.
+	if variadic != 0 && len(inputs) > 0 {
+		inputs = inputs[:len(inputs)-1]
+		typ, ok := funcType(variadic)
+		if !ok {
+			return function{}, false
 		}
+		fn.variadic = typ
+	}
 
-		out, ok := types.OidToType[returnType]
+	fn.inputs = make([]types.T, len(inputs))
+	for i, oid := range inputs {
+		t, ok := funcType(oid)
 		if !ok {
-			continue
+			return function{}, false
 		}
+		fn.inputs[i] = t
+	}
+
+	out, ok := funcType(returnType)
+	if !ok {
+		return function{}, false
+	}
+	fn.out = out
+	return fn, true
+}
 
-		result[returnType] = append(result[returnType], function{
-			name,
-			typs,
-			out,
+// builtinOperators returns the operators extractOperators loads from
+// pg_operator, derived from the builtins as the server derives pg_operator.
+// Prefix operators are left out, as they are by extractOperators.
+func builtinOperators() map[oid.Oid][]operator {
+	result := make(map[oid.Oid][]operator)
+	add := func(name string, params tree.TypeList, returnTyper tree.ReturnTyper) {
+		typs := params.Types()
+		if len(typs) != 2 {
+			return
+		}
+		out := returnTyper(nil).Oid()
+		if op, ok := makeOperator(name, typs[0].Oid(), typs[1].Oid(), out); ok {
+			result[out] = append(result[out], op)
+		}
+	}
+	for cmpOp, overloads := range tree.CmpOps {
+		// IN isn't a general operator, since its right side must be a tuple
+		// or subquery.
+		if cmpOp == tree.In {
+			continue
+		}
+		for _, overload := range overloads {
+			params, returnTyper := tree.GetParamsAndReturnType(overload)
+			add(cmpOp.String(), params, returnTyper)
+			if inverse, ok := cmpOp.Inverse(); ok {
+				add(inverse.String(), params, returnTyper)
+			}
+		}
+	}
+	for binOp, overloads := range tree.BinOps {
+		for _, overload := range overloads {
+			params, returnTyper := tree.GetParamsAndReturnType(overload)
+			add(binOp.String(), params, returnTyper)
+		}
+	}
+	// The operators are sorted, since they're ranged over in random order,
+	// so that statements generated from the same seed are identical.
+	for _, ops := range result {
+		sort.Slice(ops, func(i, j int) bool {
+			return fmt.Sprint(ops[i]) < fmt.Sprint(ops[j])
 		})
 	}
 	return result
 }
+
+// builtinFunctions returns the functions extractFunctions loads from pg_proc,
+// derived from the builtins as the server derives pg_proc.
+func builtinFunctions() map[oid.Oid][]function {
+	result := make(map[oid.Oid][]function)
+	for _, name := range builtins.AllBuiltinNames {
+		// The builtins are listed under their upper case names too, which
+		// pg_proc leaves out.
+		if r, _ := utf8.DecodeRuneInString(name); unicode.IsUpper(r) {
+			continue
+		}
+		if name == "crdb_internal.force_panic" || name == "crdb_internal.force_log_fatal" {
+			continue
+		}
+		props, overloads := builtins.GetBuiltinProperties(name)
+		if props.Class == tree.AggregateClass || props.Class == tree.WindowClass {
+			continue
+		}
+		for _, overload := range overloads {
+			// Those returning sets, and those whose return type depends on
+			// their arguments, have no return type in pg_proc.
+			out := overload.FixedReturnType()
+			if out == nil || overload.Generator != nil {
+				continue
+			}
+			var inputs []oid.Oid
+			for _, typ := range overload.Types.Types() {
+				inputs = append(inputs, typ.Oid())
+			}
+			var variadic oid.Oid
+			switch t := overload.Types.(type) {
+			case tree.VariadicType:
+				variadic = t.VarType.Oid()
+			case tree.HomogeneousType:
+				variadic = types.Any.Oid()
+			}
+			if fn, ok := makeFunction(name, inputs, out.Oid(), variadic, 0); ok {
+				result[fn.out.Oid()] = append(result[fn.out.Oid()], fn)
+			}
+		}
+	}
+	markOverloaded(result)
+	return result
+}
+
+// markOverloaded marks the functions which share their name with another
+// function as overloaded.
+func markOverloaded(functions map[oid.Oid][]function) {
+	counts := make(map[string]int)
+	for _, fns := range functions {
+		for _, fn := range fns {
+			counts[fn.name]++
+		}
+	}
+	for _, fns := range functions {
+		for i := range fns {
+			fns[i].overloaded = counts[fns[i].name] > 1
+		}
+	}
+}
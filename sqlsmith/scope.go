@@ -2,7 +2,9 @@ package sqlsmith
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
 )
 
@@ -27,10 +29,60 @@ type namedRelation struct {
 
 type table struct {
 	namedRelation
+	catalog      string
 	schema       string
 	isInsertable bool
 	isBaseTable  bool
-	constraints  []string
+	// isView is set for views, including materialized views, which are also
+	// isMaterialized.
+	isView         bool
+	isMaterialized bool
+
+	// constraints are the names of all the table's constraints.
+	constraints []string
+	indexes     []index
+	foreignKeys []foreignKey
+	// checks are the expressions of the table's check constraints.
+	checks []string
+}
+
+// col returns the column of the table with the given name.
+func (t *table) col(name string) (column, bool) {
+	for _, c := range t.cols {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return column{}, false
+}
+
+// qualifiedName returns the name of the table qualified by its catalog and
+// schema, if it has them.
+func (t *table) qualifiedName() string {
+	var parts []string
+	for _, p := range []string{t.catalog, t.schema, t.name} {
+		if p != "" {
+			parts = append(parts, tree.NameString(p))
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+type sequence struct {
+	catalog string
+	schema  string
+	name    string
+}
+
+// qualifiedName returns the name of the sequence qualified by its catalog
+// and schema, if it has them.
+func (s sequence) qualifiedName() string {
+	t := table{
+		namedRelation: namedRelation{name: s.name},
+		catalog:       s.catalog,
+		schema:        s.schema,
+	}
+	return t.qualifiedName()
 }
 
 // namer is a helper to generate names with unique prefixes.
@@ -44,7 +96,9 @@ func (n *namer) name(prefix string) string {
 }
 
 type scope struct {
-	schema *schema
+	// Smither holds the schema we generate against, the source of all random
+	// decisions, and any options affecting generation.
+	*Smither
 
 	// level is how deep we are in the scope tree - it is used as a heuristic
 	// to eventually bottom out recursion (so we don't attempt to construct an
@@ -58,19 +112,107 @@ type scope struct {
 	// namer is used to generate unique table and column names.
 	namer *namer
 
+	// readOnly prevents statements which modify data from being generated in
+	// this scope, such as in the body of a view.
+	readOnly bool
+
+	// scalarOnly prevents subqueries and sequence functions from being
+	// generated in this scope, such as in computed columns and check
+	// constraints.
+	scalarOnly bool
+
+	// prepare is set if constants may be replaced by placeholders, in a
+	// statement to be prepared.
+	prepare bool
+
+	// spent is how much of the budget the statement has used. It is shared by
+	// every scope of a statement, like namer. subqueryDepth is how many
+	// subqueries this scope is nested in.
+	spent         *spent
+	subqueryDepth int
+
+	// cols indexes the columns of refs by type. It's built when a column is
+	// first looked up, and rebuilt if refs has grown since.
+	cols *colIndex
+
 	// expr is the expression associated with this scope.
 	expr relExpr
 }
 
+// scopeColumn is a column of one of a scope's refs.
+type scopeColumn struct {
+	ref tableRef
+	col column
+}
+
+type colIndex struct {
+	// numRefs is the number of refs the index was built from.
+	numRefs int
+	all     []scopeColumn
+	// byType is keyed by typeName.
+	byType map[string][]scopeColumn
+}
+
+// columns returns the index of the columns which can be referenced in this
+// scope.
+func (s *scope) columns() *colIndex {
+	if s.cols != nil && s.cols.numRefs == len(s.refs) {
+		return s.cols
+	}
+	idx := &colIndex{
+		numRefs: len(s.refs),
+		byType:  make(map[string][]scopeColumn),
+	}
+	for _, ref := range s.refs {
+		for _, c := range ref.Cols() {
+			sc := scopeColumn{ref: ref, col: c}
+			idx.all = append(idx.all, sc)
+			idx.byType[typeName(c.typ)] = append(idx.byType[typeName(c.typ)], sc)
+		}
+	}
+	s.cols = idx
+	return idx
+}
+
+// candidates returns the columns which can be referenced as typ, and
+// whether they need to be cast to it.
+func (idx *colIndex) candidates(typ types.T) ([]scopeColumn, bool) {
+	if typ == types.Any {
+		return idx.all, false
+	}
+	if exact := idx.byType[typeName(typ)]; len(exact) > 0 {
+		return exact, false
+	}
+	var castable []scopeColumn
+	for _, sc := range idx.all {
+		if isCastable(sc.col.typ, typ) {
+			castable = append(castable, sc)
+		}
+	}
+	return castable, true
+}
+
 func (s *scope) push() *scope {
 	return &scope{
-		level:  s.level + 1,
-		refs:   append(make([]tableRef, 0, len(s.refs)), s.refs...),
-		namer:  s.namer,
-		schema: s.schema,
+		level:      s.level + 1,
+		refs:       append(make([]tableRef, 0, len(s.refs)), s.refs...),
+		namer:      s.namer,
+		Smither:    s.Smither,
+		readOnly:   s.readOnly,
+		scalarOnly: s.scalarOnly,
+		prepare:    s.prepare,
+
+		spent:         s.spent,
+		subqueryDepth: s.subqueryDepth,
 	}
 }
 
+// canMutate returns whether statements which modify data may be generated in
+// this scope.
+func (s *scope) canMutate() bool {
+	return !s.disableMutations && !s.readOnly
+}
+
 func (s *scope) name(prefix string) string {
 	return s.namer.name(prefix)
 }
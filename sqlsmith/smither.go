@@ -0,0 +1,280 @@
+package sqlsmith
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+)
+
+// Smither generates random statements and expressions against a schema,
+// either one loaded from a live database or one read from a snapshot. It can
+// be used to embed sqlsmith-go in tests:
+//
+//	smither, err := sqlsmith.NewSmither(db, rand.New(rand.NewSource(seed)))
+//	if err != nil {
+//	  t.Fatal(err)
+//	}
+//	stmt := smither.Generate()
+//
+// A Smither is not safe for concurrent use. Smithers may share a schema, in
+// which case each of them should have its own source of randomness.
+type Smither struct {
+	schema *schema
+
+	// rnd is the source of all random decisions made by the Smither.
+	rnd *rand.Rand
+
+	// weights are the weights of each production, as described by
+	// defaultWeights.
+	weights map[string]int
+
+	// databases and schemas are the databases and schemas tables are loaded
+	// from, as described on schema.
+	databases []string
+	schemas   []string
+
+	disableMutations bool
+
+	// budget bounds the size of each statement.
+	budget Budget
+
+	// corpus are the statements mutated instead of generating statements
+	// from scratch, if any.
+	corpus []string
+
+	// coverage, if set, tracks the parts of the grammar exercised.
+	coverage *coverage
+}
+
+// SmitherOption configures a Smither.
+type SmitherOption func(*Smither)
+
+// DisableMutations prevents the Smither from generating statements which
+// modify data, like INSERT and INSERT ... RETURNING.
+func DisableMutations() SmitherOption {
+	return func(s *Smither) {
+		s.disableMutations = true
+	}
+}
+
+// Weights sets the weights of each production, as returned by MakeWeights.
+func Weights(w map[string]int) SmitherOption {
+	return func(s *Smither) {
+		s.weights = w
+	}
+}
+
+// SizeBudget sets the budget bounding the size of each statement, as
+// returned by MakeBudget.
+func SizeBudget(b Budget) SmitherOption {
+	return func(s *Smither) {
+		s.budget = b
+	}
+}
+
+// Databases sets the databases tables are loaded from. By default, only the
+// current database is used.
+func Databases(names ...string) SmitherOption {
+	return func(s *Smither) {
+		s.databases = names
+	}
+}
+
+// Schemas sets the schemas tables are loaded from. By default, only the
+// public schema is used. Virtual schemas, like pg_catalog or crdb_internal,
+// can be included, and their tables are only read from.
+func Schemas(names ...string) SmitherOption {
+	return func(s *Smither) {
+		s.schemas = names
+	}
+}
+
+// NewSmither returns a Smither which generates against the schema of db.
+func NewSmither(db *sql.DB, rnd *rand.Rand, opts ...SmitherOption) (*Smither, error) {
+	s := newSmither(nil, rnd, opts...)
+	schema, err := makeSchema(db, s.databases, s.schemas)
+	if err != nil {
+		return nil, err
+	}
+	s.schema = schema
+	return s, nil
+}
+
+// NewSmitherFromSnapshot returns a Smither which generates against the
+// schema snapshot at path, without a live database. The snapshot is either a
+// JSON snapshot, as written by DumpSchema, or a .sql file of CREATE TABLE
+// statements, whose operators and functions are the builtins.
+func NewSmitherFromSnapshot(path string, rnd *rand.Rand, opts ...SmitherOption) (*Smither, error) {
+	schema, err := loadSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	return newSmither(schema, rnd, opts...), nil
+}
+
+func newSmither(schema *schema, rnd *rand.Rand, opts ...SmitherOption) *Smither {
+	s := &Smither{
+		schema:  schema,
+		rnd:     rnd,
+		weights: defaultWeights,
+		budget:  budgets["default"],
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Smither) makeScope() *scope {
+	return &scope{
+		namer:   &namer{make(map[string]int)},
+		spent:   &spent{},
+		Smither: s,
+	}
+}
+
+// StatementKind is a kind of statement a Smither can generate.
+type StatementKind int
+
+const (
+	// AnyStatement is any of the kinds below.
+	AnyStatement StatementKind = iota
+	SelectStatement
+	InsertStatement
+	ValuesStatement
+	SetOpStatement
+	// ViewStatement is a CREATE VIEW, CREATE MATERIALIZED VIEW or REFRESH.
+	ViewStatement
+	// DDLStatement is a random schema change to one of the tables.
+	DDLStatement
+)
+
+func (k StatementKind) String() string {
+	switch k {
+	case AnyStatement:
+		return "any"
+	case SelectStatement:
+		return "select"
+	case InsertStatement:
+		return "insert"
+	case ValuesStatement:
+		return "values"
+	case SetOpStatement:
+		return "set op"
+	case ViewStatement:
+		return "view"
+	case DDLStatement:
+		return "ddl"
+	default:
+		return fmt.Sprintf("StatementKind(%d)", int(k))
+	}
+}
+
+// generate makes a statement of the given kind, holding the schema's
+// read lock while it does.
+func (s *Smither) generate(kind StatementKind) (relExpr, bool) {
+	s.schema.RLock()
+	defer s.schema.RUnlock()
+	return s.makeScope().makeStmtOfKind(kind)
+}
+
+func (s *scope) makeStmtOfKind(kind StatementKind) (relExpr, bool) {
+	var out *scope
+	var ok bool
+	switch kind {
+	case AnyStatement:
+		out, ok = s.makeStmt()
+	case SelectStatement:
+		out, ok = s.makeSelect(nil)
+	case InsertStatement:
+		if !s.canMutate() {
+			return nil, false
+		}
+		out, ok = s.makeInsert()
+	case ValuesStatement:
+		out, ok = s.makeValues(nil)
+	case SetOpStatement:
+		out, ok = s.makeSetOp(nil)
+	case ViewStatement:
+		out, ok = s.makeView()
+	case DDLStatement:
+		if !s.canMutate() {
+			return nil, false
+		}
+		out, ok = s.makeDDL()
+	}
+	if !ok || !s.fitsLength(out.expr) {
+		return nil, false
+	}
+	return out.expr, true
+}
+
+// Generate returns a random statement. It panics if no statement can be
+// generated, for instance because the weights or budgets rule them all out,
+// or with a *GeneratorBugError if the statement doesn't round trip through
+// the parser.
+func (s *Smither) Generate() string {
+	stmt, err := s.GenerateAST(AnyStatement)
+	if err != nil {
+		panic(err)
+	}
+	return prettyPrint(stmt)
+}
+
+// GenerateStatement returns a random statement of the given kind. It returns
+// an error if it can't generate one against the schema, for instance an
+// INSERT when there are no tables, or a *GeneratorBugError if the statement
+// doesn't round trip through the parser.
+func (s *Smither) GenerateStatement(kind StatementKind) (string, error) {
+	stmt, err := s.GenerateAST(kind)
+	if err != nil {
+		return "", err
+	}
+	return prettyPrint(stmt), nil
+}
+
+// GenerateAST is like GenerateStatement, but returns the statement's AST.
+func (s *Smither) GenerateAST(kind StatementKind) (tree.Statement, error) {
+	for i := 0; i < retryCount; i++ {
+		expr, ok := s.generate(kind)
+		if !ok {
+			continue
+		}
+		// Statements the parser doesn't implement have no AST.
+		stmt, err := toStatement(expr)
+		if isUnimplemented(err) {
+			continue
+		}
+		return stmt, err
+	}
+	s.coverage.abandon("statement")
+	return nil, fmt.Errorf("unable to generate %s statement", kind)
+}
+
+// GenerateExpr returns a random scalar expression of type typ, which doesn't
+// reference any columns. It panics if no expression of type typ can be
+// generated, or with a *GeneratorBugError if the expression doesn't round
+// trip through the parser.
+func (s *Smither) GenerateExpr(typ types.T) string {
+	expr, err := s.GenerateExprAST(typ)
+	if err != nil {
+		panic(err)
+	}
+	return tree.AsString(expr)
+}
+
+// GenerateExprAST is like GenerateExpr, but returns the expression's AST,
+// and an error instead of panicking.
+func (s *Smither) GenerateExprAST(typ types.T) (tree.Expr, error) {
+	s.schema.RLock()
+	defer s.schema.RUnlock()
+	for i := 0; i < retryCount; i++ {
+		if expr, ok := s.makeScope().makeScalar(typ); ok {
+			return toExpr(expr)
+		}
+	}
+	return nil, fmt.Errorf("unable to generate %s expression", typ)
+}
@@ -0,0 +1,460 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+const (
+	testSchema = "testdata/schema.json"
+	numSeeds   = 200
+)
+
+func newTestSmither(t *testing.T, seed int64) *Smither {
+	t.Helper()
+	schema, err := loadSnapshot(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSmither(schema, rand.New(rand.NewSource(seed)))
+}
+
+func formatExpr(f Format) string {
+	var buf bytes.Buffer
+	f.Format(&buf)
+	return buf.String()
+}
+
+func TestGenerateParses(t *testing.T) {
+	kinds := []StatementKind{
+		AnyStatement, SelectStatement, InsertStatement, ValuesStatement, SetOpStatement,
+		ViewStatement, DDLStatement,
+	}
+	for seed := int64(0); seed < numSeeds; seed++ {
+		s := newTestSmither(t, seed)
+		for _, kind := range kinds {
+			expr, ok := s.generate(kind)
+			if !ok {
+				continue
+			}
+			sql := formatExpr(expr)
+			if _, err := parser.ParseOne(sql); err != nil && !isUnimplemented(err) {
+				t.Errorf("seed %d: %s statement doesn't parse: %v\n%s", seed, kind, err, sql)
+			}
+		}
+	}
+}
+
+func TestGenerateQuotesNames(t *testing.T) {
+	// None of these names parse unquoted.
+	snap, err := snapshotFromSQL(`CREATE TABLE t (
+		"two words" INT PRIMARY KEY,
+		"select" INT UNIQUE,
+		"from" STRING,
+		INDEX "an index" ("from"),
+		CONSTRAINT "a check" CHECK ("select" > 0)
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := schemaFromSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seed := int64(0); seed < numSeeds; seed++ {
+		s := newSmither(schema, rand.New(rand.NewSource(seed)))
+		for _, kind := range []StatementKind{SelectStatement, InsertStatement, DDLStatement} {
+			if _, err := s.GenerateStatement(kind); err != nil {
+				if _, ok := err.(*GeneratorBugError); ok {
+					t.Errorf("seed %d: %v", seed, err)
+				}
+			}
+		}
+	}
+}
+
+// env maps the names tables can be referenced by to their columns.
+type env map[string][]column
+
+func (e env) with(refs ...*tableExpr) env {
+	result := make(env, len(e)+len(refs))
+	for n, cols := range e {
+		result[n] = cols
+	}
+	for _, r := range refs {
+		result[r.alias] = r.rel.cols
+	}
+	return result
+}
+
+// refChecker reports every column reference which doesn't resolve.
+type refChecker struct {
+	// tables are the tables of the schema, by qualified name.
+	tables map[string]table
+	report func(ref string)
+}
+
+func (c *refChecker) rel(r relExpr, e env) {
+	switch r := r.(type) {
+	case *selectExpr:
+		var refs []*tableExpr
+		for _, from := range r.fromClause {
+			refs = append(refs, tableExprs(from)...)
+		}
+		inner := e.with(refs...)
+		for _, from := range r.fromClause {
+			c.rel(from, inner)
+		}
+		for _, s := range r.selectList {
+			c.scalar(s, inner)
+		}
+		if r.filter != nil {
+			c.scalar(r.filter, inner)
+		}
+	case *join:
+		c.rel(r.lhs, e)
+		c.rel(r.rhs, e)
+		if r.on != nil {
+			c.scalar(r.on, e)
+		}
+	case *insert:
+		c.rel(r.input, e)
+	case *insertReturning:
+		c.rel(r.input, e)
+		// The returning list refers to the target by its unqualified name.
+		target := c.tables[r.target]
+		for _, ret := range r.returning {
+			c.scalar(ret, e.with(&tableExpr{alias: target.name, rel: target}))
+		}
+	case *values:
+		for _, row := range r.values {
+			for _, v := range row {
+				c.scalar(v, e)
+			}
+		}
+	case *setOp:
+		c.rel(r.left, e)
+		c.rel(r.right, e)
+	case *createView:
+		c.rel(r.body, env{})
+	}
+}
+
+func (c *refChecker) scalar(s scalarExpr, e env) {
+	switch s := s.(type) {
+	case *colRefExpr:
+		parts := strings.SplitN(s.ref, ".", 2)
+		if len(parts) != 2 {
+			c.report(s.ref)
+			return
+		}
+		for _, col := range e[parts[0]] {
+			if col.name == parts[1] {
+				return
+			}
+		}
+		c.report(s.ref)
+	case *caseExpr:
+		c.scalar(s.condition, e)
+		c.scalar(s.trueExpr, e)
+		c.scalar(s.falseExpr, e)
+	case *coalesceExpr:
+		c.scalar(s.firstExpr, e)
+		c.scalar(s.secondExpr, e)
+	case *opExpr:
+		c.scalar(s.left, e)
+		c.scalar(s.right, e)
+	case *funcExpr:
+		for _, in := range s.inputs {
+			c.scalar(in, e)
+		}
+	case *castExpr:
+		c.scalar(s.expr, e)
+	case *sequenceExpr:
+		if s.value != nil {
+			c.scalar(s.value, e)
+		}
+	case *exists:
+		c.rel(s.subquery, e)
+	case *scalarSubq:
+		c.rel(s.subquery, e)
+	}
+}
+
+func TestColumnRefsResolve(t *testing.T) {
+	for seed := int64(0); seed < numSeeds; seed++ {
+		s := newTestSmither(t, seed)
+		tables := make(map[string]table)
+		for _, tab := range s.schema.tables {
+			tables[tab.qualifiedName()] = tab
+		}
+		for _, kind := range []StatementKind{AnyStatement, SelectStatement, ViewStatement} {
+			expr, ok := s.generate(kind)
+			if !ok {
+				continue
+			}
+			c := refChecker{tables: tables, report: func(ref string) {
+				t.Errorf("seed %d: column reference %s doesn't resolve\n%s", seed, ref, formatExpr(expr))
+			}}
+			c.rel(expr, env{})
+		}
+	}
+}
+
+func TestSelectListTypes(t *testing.T) {
+	for seed := int64(0); seed < numSeeds; seed++ {
+		s := newTestSmither(t, seed)
+		sc := s.makeScope()
+		desired := []types.T{sc.randType()}
+		for sc.coin() {
+			desired = append(desired, sc.randType())
+		}
+		out, ok := sc.makeSelect(desired)
+		if !ok {
+			continue
+		}
+		sel := out.expr.(*selectExpr)
+		if len(sel.selectList) != len(desired) {
+			t.Fatalf("seed %d: wanted %d columns, got %d\n%s",
+				seed, len(desired), len(sel.selectList), formatExpr(sel))
+		}
+		for i, e := range sel.selectList {
+			if !e.Type().Equivalent(desired[i]) {
+				t.Errorf("seed %d: column %d is %s, wanted %s\n%s",
+					seed, i, e.Type(), desired[i], formatExpr(sel))
+			}
+		}
+	}
+}
+
+func TestInsertTargets(t *testing.T) {
+	for seed := int64(0); seed < numSeeds; seed++ {
+		s := newTestSmither(t, seed)
+		out, ok := s.makeScope().makeInsert()
+		if !ok {
+			continue
+		}
+		ins := out.expr.(*insert)
+		targets := make(map[string]bool)
+		for _, c := range ins.targets {
+			targets[c.name] = true
+		}
+		rel := out.refs[len(out.refs)-1].(*tableExpr).rel
+		for _, c := range rel.cols {
+			if c.writability == writable && !c.nullable && !targets[c.name] {
+				t.Errorf("seed %d: insert into %s doesn't write non-nullable column %s\n%s",
+					seed, rel.name, c.name, formatExpr(ins))
+			}
+		}
+	}
+}
+
+func TestPreparedPlaceholders(t *testing.T) {
+	for seed := int64(0); seed < numSeeds; seed++ {
+		s := newTestSmither(t, seed)
+		expr, typs, ok := s.generatePrepared()
+		if !ok {
+			continue
+		}
+		sql := formatExpr(expr)
+		stmt, err := parser.ParseOne(sql)
+		if isUnimplemented(err) {
+			continue
+		} else if err != nil {
+			t.Fatalf("seed %d: %v\n%s", seed, err, sql)
+		}
+		used := make(map[types.PlaceholderIdx]bool)
+		ctx := tree.NewFmtCtx(tree.FmtSimple)
+		ctx.SetPlaceholderFormat(func(_ *tree.FmtCtx, p *tree.Placeholder) {
+			used[p.Idx] = true
+		})
+		ctx.FormatNode(stmt.AST)
+		// Every placeholder up to the highest one should be used.
+		if len(used) != len(typs) || stmt.NumPlaceholders != len(typs) {
+			t.Errorf("seed %d: %d placeholders typed, %d used, up to $%d\n%s",
+				seed, len(typs), len(used), stmt.NumPlaceholders, sql)
+		}
+	}
+}
+
+func TestNumberPlaceholders(t *testing.T) {
+	sc := newTestSmither(t, 0).makeScope()
+	// The first placeholder is left out, as if the expression it was generated
+	// in were abandoned.
+	sc.makePlaceholder(types.Int)
+	kept := sc.makePlaceholder(types.String).(*placeholderExpr)
+	typs := numberPlaceholders(&values{values: [][]scalarExpr{{kept}}})
+	if len(typs) != 1 || typs[0] != types.String || kept.n != 1 {
+		t.Errorf("expected one string placeholder numbered 1, got %v numbered %d", typs, kept.n)
+	}
+}
+
+func TestGenerateExprASTGivesUp(t *testing.T) {
+	// No expression has the pseudo-type anyarray itself.
+	if _, err := newTestSmither(t, 0).GenerateExprAST(types.AnyArray); err == nil {
+		t.Error("expected an error generating an anyarray expression")
+	}
+}
+
+func TestGenerateASTGivesUp(t *testing.T) {
+	s := newTestSmither(t, 0)
+	// No statement is this short.
+	s.budget.MaxLength = 1
+	if _, err := s.GenerateAST(AnyStatement); err == nil {
+		t.Error("expected an error generating a statement within the length budget")
+	}
+}
+
+func TestLengthBudget(t *testing.T) {
+	// Productions stop recursing before the length budget is spent, so few
+	// statements are thrown away for being too long.
+	var failed int
+	for seed := int64(0); seed < numSeeds; seed++ {
+		s := newTestSmither(t, seed)
+		s.budget.MaxLength = 1000
+		if _, ok := s.generate(AnyStatement); !ok {
+			failed++
+		}
+	}
+	if failed > numSeeds/20 {
+		t.Errorf("%d of %d statements weren't within the length budget", failed, numSeeds)
+	}
+}
+
+func TestDeeper(t *testing.T) {
+	sc := newTestSmither(t, 0).makeScope()
+	sc.level = 10
+	for _, tc := range []struct {
+		maxDepth int
+		expected bool
+	}{
+		{maxDepth: 6, expected: false},
+		{maxDepth: 30, expected: true},
+	} {
+		sc.budget.MaxDepth = tc.maxDepth
+		deeper := false
+		for i := 0; i < 100 && !deeper; i++ {
+			deeper = sc.deeper(0)
+		}
+		if deeper != tc.expected {
+			t.Errorf("at level %d with depth budget %d, expected deeper to be possible: %t",
+				sc.level, tc.maxDepth, tc.expected)
+		}
+	}
+}
+
+func TestUsedOverloads(t *testing.T) {
+	s := newTestSmither(t, 0)
+	s.coverage = makeCoverage()
+	kept := &opExpr{outTyp: types.Int, left: &placeholderExpr{typ: types.Int},
+		right: &placeholderExpr{typ: types.Int}, op: "+", overload: "int + int"}
+	st := s.makeStatement(&values{values: [][]scalarExpr{{kept}}})
+	if len(st.overloads) != 1 || st.overloads[0] != kept.overload {
+		t.Errorf("expected overloads [%s], got %v", kept.overload, st.overloads)
+	}
+	if len(s.coverage.emitted) != 1 || s.coverage.emitted[kept.overload] != 1 {
+		t.Errorf("expected %s to be emitted once, got %v", kept.overload, s.coverage.emitted)
+	}
+}
+
+func TestScalarSubqueries(t *testing.T) {
+	sub := func(limited bool) *scalarSubq {
+		return &scalarSubq{
+			subquery: &selectExpr{selectList: []scalarExpr{&placeholderExpr{typ: types.Int}}},
+			limited:  limited,
+		}
+	}
+	unlimited := sub(false)
+	impure := sub(false)
+	impure.subquery.(*selectExpr).selectList[0] = &funcExpr{name: "random", outTyp: types.Float}
+	expr := &values{values: [][]scalarExpr{{sub(true), unlimited, impure}}}
+	// Only subqueries without LIMIT 1 can return more than one row, and
+	// only pure ones return the same thing on their own.
+	if subs := scalarSubqueries(expr); len(subs) != 1 || subs[0] != unlimited.subquery {
+		t.Errorf("expected only the pure subquery without LIMIT 1, got %v", subs)
+	}
+
+	// The data may have changed since the statement was executed, so more
+	// rows are only noted.
+	check := makeResultCheck(unlimited.subquery, true /* scalar */)
+	check.row(nil)
+	check.row(nil)
+	if violations := check.finish(); len(violations) != 0 || len(check.noted()) != 1 {
+		t.Errorf("expected a note and no violations, got %v and %v", check.noted(), violations)
+	}
+}
+
+func TestIsDeterministic(t *testing.T) {
+	sel := func(e scalarExpr, limit string) *selectExpr {
+		return &selectExpr{selectList: []scalarExpr{e}, limit: limit}
+	}
+	one := &constExpr{typ: types.Int, expr: "1"}
+	for _, tc := range []struct {
+		expr     relExpr
+		expected bool
+	}{
+		{expr: sel(one, ""), expected: true},
+		{expr: sel(one, "limit 1"), expected: false},
+		{expr: sel(&funcExpr{name: "random", outTyp: types.Float}, ""), expected: false},
+		{expr: sel(&funcExpr{name: "abs", outTyp: types.Int, inputs: []scalarExpr{one}}, ""), expected: true},
+		{expr: sel(&sequenceExpr{fn: "nextval", seq: "s"}, ""), expected: false},
+	} {
+		if got := isDeterministic(tc.expr); got != tc.expected {
+			t.Errorf("%s: expected deterministic to be %t", formatExpr(tc.expr), tc.expected)
+		}
+	}
+}
+
+// TestGolden checks the distribution of statements and productions
+// generated from a fixed seed against a golden file, so changes to it are
+// noticed. Run with -update to rewrite the golden file after an intended
+// change.
+func TestGolden(t *testing.T) {
+	const path = "testdata/distribution.golden"
+	s := newTestSmither(t, 1)
+	s.coverage = makeCoverage()
+	statements := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		if expr, ok := s.generate(AnyStatement); ok {
+			statements[productionName(expr)]++
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, n := range sortedKeys(statements) {
+		fmt.Fprintf(&buf, "statement %s %d\n", n, statements[n])
+	}
+	for _, n := range sortedKeys(s.coverage.attempts) {
+		fmt.Fprintf(&buf, "production %s %d attempted %d failed\n",
+			n, s.coverage.attempts[n], s.coverage.failures[n])
+	}
+
+	if *update {
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Fatalf("%s doesn't exist; run with -update to create it", path)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(want) {
+		t.Errorf("distribution differs from %s; run with -update if this is intended\ngot:\n%s",
+			path, got)
+	}
+}
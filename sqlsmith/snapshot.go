@@ -0,0 +1,446 @@
+package sqlsmith
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/coltypes"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/lib/pq/oid"
+)
+
+// A snapshot is a serialized schema, which lets us generate statements
+// without a live database. Types are stored by name, and resolved with
+// typeFromName when the snapshot is loaded.
+type snapshot struct {
+	Tables    []snapshotTable    `json:"tables"`
+	Sequences []snapshotSequence `json:"sequences,omitempty"`
+	Operators []snapshotOperator `json:"operators,omitempty"`
+	Functions []snapshotFunction `json:"functions,omitempty"`
+}
+
+type snapshotTable struct {
+	Catalog  string `json:"catalog,omitempty"`
+	Schema   string `json:"schema,omitempty"`
+	Name     string `json:"name"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+	// View is set for views, and Materialized for materialized views.
+	View         bool             `json:"view,omitempty"`
+	Materialized bool             `json:"materialized,omitempty"`
+	Columns      []snapshotColumn `json:"columns"`
+
+	Constraints []string             `json:"constraints,omitempty"`
+	Indexes     []snapshotIndex      `json:"indexes,omitempty"`
+	ForeignKeys []snapshotForeignKey `json:"foreignKeys,omitempty"`
+	Checks      []string             `json:"checks,omitempty"`
+}
+
+type snapshotSequence struct {
+	Catalog string `json:"catalog,omitempty"`
+	Schema  string `json:"schema,omitempty"`
+	Name    string `json:"name"`
+}
+
+type snapshotIndex struct {
+	Name string `json:"name"`
+	// Columns are the indexed columns, with " DESC" appended to those in
+	// descending order.
+	Columns   []string `json:"columns"`
+	Storing   []string `json:"storing,omitempty"`
+	Unique    bool     `json:"unique,omitempty"`
+	Primary   bool     `json:"primary,omitempty"`
+	Inverted  bool     `json:"inverted,omitempty"`
+	Predicate string   `json:"predicate,omitempty"`
+}
+
+type snapshotForeignKey struct {
+	Name     string   `json:"name"`
+	Columns  []string `json:"columns"`
+	RefTable string   `json:"refTable"`
+	RefCols  []string `json:"refColumns"`
+}
+
+const descSuffix = " DESC"
+
+func (idx index) snapshot() snapshotIndex {
+	si := snapshotIndex{
+		Name:      idx.name,
+		Storing:   idx.storing,
+		Unique:    idx.unique,
+		Primary:   idx.primary,
+		Inverted:  idx.inverted,
+		Predicate: idx.predicate,
+	}
+	for _, c := range idx.cols {
+		name := c.name
+		if c.descending {
+			name += descSuffix
+		}
+		si.Columns = append(si.Columns, name)
+	}
+	return si
+}
+
+func (si snapshotIndex) index() index {
+	idx := index{
+		name:      si.Name,
+		storing:   si.Storing,
+		unique:    si.Unique,
+		primary:   si.Primary,
+		inverted:  si.Inverted,
+		predicate: si.Predicate,
+	}
+	for _, c := range si.Columns {
+		idx.cols = append(idx.cols, indexColumn{
+			name:       strings.TrimSuffix(c, descSuffix),
+			descending: strings.HasSuffix(c, descSuffix),
+		})
+	}
+	return idx
+}
+
+type snapshotColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable,omitempty"`
+	Computed bool   `json:"computed,omitempty"`
+}
+
+type snapshotOperator struct {
+	Name  string `json:"name"`
+	Left  string `json:"left"`
+	Right string `json:"right"`
+	Out   string `json:"out"`
+}
+
+type snapshotFunction struct {
+	Name     string   `json:"name"`
+	Inputs   []string `json:"inputs"`
+	Out      string   `json:"out"`
+	Variadic string   `json:"variadic,omitempty"`
+	Defaults int      `json:"defaults,omitempty"`
+}
+
+// typeName returns a name for typ which typeFromName understands.
+func typeName(typ types.T) string {
+	if c, ok := typ.(types.TCollatedString); ok {
+		return "STRING COLLATE " + c.Locale
+	}
+	return typ.String()
+}
+
+func (s *schema) snapshot() snapshot {
+	s.RLock()
+	defer s.RUnlock()
+
+	var snap snapshot
+	for _, t := range s.tables {
+		st := snapshotTable{
+			Catalog:      t.catalog,
+			Schema:       t.schema,
+			Name:         t.name,
+			ReadOnly:     !t.isInsertable,
+			View:         t.isView,
+			Materialized: t.isMaterialized,
+			Constraints:  t.constraints,
+			Checks:       t.checks,
+		}
+		for _, idx := range t.indexes {
+			st.Indexes = append(st.Indexes, idx.snapshot())
+		}
+		for _, fk := range t.foreignKeys {
+			st.ForeignKeys = append(st.ForeignKeys, snapshotForeignKey{
+				Name:     fk.name,
+				Columns:  fk.cols,
+				RefTable: fk.refTable,
+				RefCols:  fk.refCols,
+			})
+		}
+		for _, c := range t.cols {
+			st.Columns = append(st.Columns, snapshotColumn{
+				Name:     c.name,
+				Type:     typeName(c.typ),
+				Nullable: c.nullable,
+				Computed: c.writability == notWritable,
+			})
+		}
+		snap.Tables = append(snap.Tables, st)
+	}
+	for _, seq := range s.sequences {
+		snap.Sequences = append(snap.Sequences, snapshotSequence{
+			Catalog: seq.catalog,
+			Schema:  seq.schema,
+			Name:    seq.name,
+		})
+	}
+	opOids := make([]oid.Oid, 0, len(s.operators))
+	for o := range s.operators {
+		opOids = append(opOids, o)
+	}
+	// Sort by oid so that snapshots of the same schema are identical.
+	sort.Slice(opOids, func(i, j int) bool { return opOids[i] < opOids[j] })
+	for _, o := range opOids {
+		for _, op := range s.operators[o] {
+			snap.Operators = append(snap.Operators, snapshotOperator{
+				Name:  op.name,
+				Left:  typeName(op.left),
+				Right: typeName(op.right),
+				Out:   typeName(op.out),
+			})
+		}
+	}
+	funcOids := make([]oid.Oid, 0, len(s.functions))
+	for o := range s.functions {
+		funcOids = append(funcOids, o)
+	}
+	sort.Slice(funcOids, func(i, j int) bool { return funcOids[i] < funcOids[j] })
+	for _, o := range funcOids {
+		for _, fn := range s.functions[o] {
+			sf := snapshotFunction{
+				Name:     fn.name,
+				Inputs:   []string{},
+				Out:      typeName(fn.out),
+				Defaults: fn.defaults,
+			}
+			for _, in := range fn.inputs {
+				sf.Inputs = append(sf.Inputs, typeName(in))
+			}
+			if fn.variadic != nil {
+				sf.Variadic = typeName(fn.variadic)
+			}
+			snap.Functions = append(snap.Functions, sf)
+		}
+	}
+	return snap
+}
+
+// schemaFromSnapshot builds a schema with no database behind it from snap.
+// It fails if snap names a type we don't know of.
+func schemaFromSnapshot(snap snapshot) (*schema, error) {
+	s := &schema{
+		operators: make(map[oid.Oid][]operator),
+		functions: make(map[oid.Oid][]function),
+	}
+	for _, st := range snap.Tables {
+		rel := table{
+			namedRelation:  namedRelation{name: st.Name},
+			catalog:        st.Catalog,
+			schema:         st.Schema,
+			isBaseTable:    !st.ReadOnly && !st.View && !st.Materialized,
+			isInsertable:   !st.ReadOnly && !st.View && !st.Materialized,
+			isView:         st.View || st.Materialized,
+			isMaterialized: st.Materialized,
+			constraints:    st.Constraints,
+			checks:         st.Checks,
+		}
+		for _, si := range st.Indexes {
+			rel.indexes = append(rel.indexes, si.index())
+		}
+		for _, sfk := range st.ForeignKeys {
+			rel.foreignKeys = append(rel.foreignKeys, foreignKey{
+				name:     sfk.Name,
+				cols:     sfk.Columns,
+				refTable: sfk.RefTable,
+				refCols:  sfk.RefCols,
+			})
+		}
+		for _, sc := range st.Columns {
+			typ, err := typeFromName(sc.Type)
+			if err != nil {
+				return nil, fmt.Errorf("column %s of %s: %v", sc.Name, st.Name, err)
+			}
+			writability := writable
+			if sc.Computed {
+				writability = notWritable
+			}
+			rel.cols = append(rel.cols, column{
+				name:        sc.Name,
+				typ:         typ,
+				nullable:    sc.Nullable,
+				writability: writability,
+			})
+		}
+		s.tables = append(s.tables, rel)
+	}
+	for _, ss := range snap.Sequences {
+		s.sequences = append(s.sequences, sequence{
+			catalog: ss.Catalog,
+			schema:  ss.Schema,
+			name:    ss.Name,
+		})
+	}
+	for _, so := range snap.Operators {
+		var typs [3]types.T
+		for i, name := range []string{so.Left, so.Right, so.Out} {
+			typ, err := typeFromName(name)
+			if err != nil {
+				return nil, fmt.Errorf("operator %s: %v", so.Name, err)
+			}
+			typs[i] = typ
+		}
+		out := typs[2]
+		s.operators[out.Oid()] = append(s.operators[out.Oid()], operator{
+			name:  so.Name,
+			left:  typs[0],
+			right: typs[1],
+			out:   out,
+		})
+	}
+	for _, sf := range snap.Functions {
+		out, err := typeFromName(sf.Out)
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %v", sf.Name, err)
+		}
+		inputs := make([]types.T, len(sf.Inputs))
+		for i, in := range sf.Inputs {
+			if inputs[i], err = typeFromName(in); err != nil {
+				return nil, fmt.Errorf("function %s: %v", sf.Name, err)
+			}
+		}
+		if sf.Defaults < 0 || sf.Defaults > len(sf.Inputs) {
+			return nil, fmt.Errorf("function %s: %d defaults for %d inputs",
+				sf.Name, sf.Defaults, len(sf.Inputs))
+		}
+		fn := function{
+			name:     sf.Name,
+			inputs:   inputs,
+			out:      out,
+			defaults: sf.Defaults,
+		}
+		if sf.Variadic != "" {
+			if fn.variadic, err = typeFromName(sf.Variadic); err != nil {
+				return nil, fmt.Errorf("function %s: %v", sf.Name, err)
+			}
+		}
+		s.functions[out.Oid()] = append(s.functions[out.Oid()], fn)
+	}
+	markOverloaded(s.functions)
+	return s, nil
+}
+
+// snapshotFromSQL builds a snapshot from a series of CREATE TABLE
+// statements. Its operators and functions are the builtins this binary was
+// built with, which may differ from those of the server the statements are
+// run against.
+func snapshotFromSQL(sql string) (snapshot, error) {
+	stmts, err := parser.Parse(sql)
+	if err != nil {
+		return snapshot{}, err
+	}
+	snap := (&schema{operators: builtinOperators(), functions: builtinFunctions()}).snapshot()
+	for _, stmt := range stmts {
+		create, ok := stmt.AST.(*tree.CreateTable)
+		if !ok {
+			return snapshot{}, fmt.Errorf("expected CREATE TABLE, found: %s", stmt.AST)
+		}
+		st := snapshotTable{
+			Catalog: create.Table.Catalog(),
+			Schema:  create.Table.Schema(),
+			Name:    create.Table.Table(),
+		}
+		// primary are the columns of a PRIMARY KEY constraint, which can't be
+		// NULL. The constraint may come before the columns.
+		primary := make(map[string]bool)
+		for _, def := range create.Defs {
+			switch def := def.(type) {
+			case *tree.ColumnTableDef:
+				typ := coltypes.CastTargetToDatumType(def.Type)
+				st.Columns = append(st.Columns, snapshotColumn{
+					Name:     string(def.Name),
+					Type:     typeName(typ),
+					Nullable: def.Nullable.Nullability != tree.NotNull && !def.PrimaryKey,
+					Computed: def.IsComputed(),
+				})
+				if def.PrimaryKey || def.Unique {
+					st.Indexes = append(st.Indexes, snapshotIndex{
+						Columns: []string{string(def.Name)},
+						Unique:  true,
+						Primary: def.PrimaryKey,
+					})
+				}
+			case *tree.IndexTableDef:
+				st.Indexes = append(st.Indexes, indexFromDef(def, false).snapshot())
+			case *tree.UniqueConstraintTableDef:
+				idx := indexFromDef(&def.IndexTableDef, true)
+				idx.primary = def.PrimaryKey
+				st.Indexes = append(st.Indexes, idx.snapshot())
+				if idx.primary {
+					for _, c := range idx.cols {
+						primary[c.name] = true
+					}
+				}
+			case *tree.ForeignKeyConstraintTableDef:
+				fk := foreignKeyFromDef(def)
+				st.ForeignKeys = append(st.ForeignKeys, snapshotForeignKey{
+					Name:     fk.name,
+					Columns:  fk.cols,
+					RefTable: fk.refTable,
+					RefCols:  fk.refCols,
+				})
+			case *tree.CheckConstraintTableDef:
+				st.Checks = append(st.Checks, def.Expr.String())
+				if def.Name != "" {
+					st.Constraints = append(st.Constraints, string(def.Name))
+				}
+			}
+		}
+		for i := range st.Columns {
+			if primary[st.Columns[i].Name] {
+				st.Columns[i].Nullable = false
+			}
+		}
+		// Unnamed constraints get names when the table is created, but we
+		// don't know what they'll be.
+		for _, idx := range st.Indexes {
+			if idx.Unique && idx.Name != "" {
+				st.Constraints = append(st.Constraints, idx.Name)
+			}
+		}
+		for _, fk := range st.ForeignKeys {
+			if fk.Name != "" {
+				st.Constraints = append(st.Constraints, fk.Name)
+			}
+		}
+		snap.Tables = append(snap.Tables, st)
+	}
+	return snap, nil
+}
+
+// loadSnapshot reads a schema from path, which is either a JSON snapshot or,
+// if it ends in .sql, a file of CREATE TABLE statements.
+func loadSnapshot(path string) (*schema, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if filepath.Ext(path) == ".sql" {
+		snap, err = snapshotFromSQL(string(contents))
+	} else {
+		err = json.Unmarshal(contents, &snap)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %v", path, err)
+	}
+	s, err := schemaFromSnapshot(snap)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %v", path, err)
+	}
+	return s, nil
+}
+
+func (snap snapshot) write(w io.Writer) error {
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
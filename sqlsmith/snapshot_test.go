@@ -0,0 +1,96 @@
+package sqlsmith
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+)
+
+func TestSchemaFromSnapshotUnknownType(t *testing.T) {
+	snap := snapshot{Functions: []snapshotFunction{{Name: "f", Out: "nonsense"}}}
+	if _, err := schemaFromSnapshot(snap); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+func TestSchemaFromSnapshotDefaults(t *testing.T) {
+	for _, defaults := range []int{-1, 2} {
+		snap := snapshot{Functions: []snapshotFunction{
+			{Name: "f", Inputs: []string{"int"}, Out: "int", Defaults: defaults},
+		}}
+		if _, err := schemaFromSnapshot(snap); err == nil {
+			t.Errorf("expected an error for %d defaults of one input", defaults)
+		}
+	}
+}
+
+func TestSchemaFromSnapshotMaterialized(t *testing.T) {
+	snap := snapshot{Tables: []snapshotTable{{Name: "v", Materialized: true}}}
+	s, err := schemaFromSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := s.tables[0]; v.isBaseTable || v.isInsertable || !v.isView {
+		t.Errorf("expected a materialized view to be a view which can't be written to, got %+v", v)
+	}
+}
+
+func TestSnapshotFromSQLPrimaryKey(t *testing.T) {
+	snap, err := snapshotFromSQL("CREATE TABLE t (PRIMARY KEY (a, b), a INT, b INT, c INT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range snap.Tables[0].Columns {
+		if expected := c.Name == "c"; c.Nullable != expected {
+			t.Errorf("expected column %s to be nullable: %t", c.Name, expected)
+		}
+	}
+}
+
+func TestSnapshotFromSQLBuiltins(t *testing.T) {
+	snap, err := snapshotFromSQL("CREATE TABLE t (a INT PRIMARY KEY)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := schemaFromSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plus := false
+	for _, op := range s.GetOperatorsByOutputType(types.Int) {
+		plus = plus || op.name == "+" && op.left == types.Int && op.right == types.Int
+	}
+	if !plus {
+		t.Error("expected INT + INT to be an operator")
+	}
+	found := make(map[string]function)
+	for _, fns := range s.functions {
+		for _, fn := range fns {
+			found[fn.name] = fn
+		}
+	}
+	if fn, ok := found["greatest"]; !ok || fn.variadic != types.Any {
+		t.Errorf("expected greatest to be variadic, got %+v", fn)
+	}
+	for _, name := range []string{"sum", "row_number", "generate_series", "crdb_internal.force_panic", "ABS"} {
+		if _, ok := found[name]; ok {
+			t.Errorf("expected no function %s", name)
+		}
+	}
+}
+
+func TestTypeFromNameUnwrapped(t *testing.T) {
+	for name, expected := range map[string]types.T{
+		"string":   types.String,
+		"int":      types.Int,
+		"float":    types.Float,
+		"string[]": types.TArray{Typ: types.String},
+		"name":     types.Name,
+	} {
+		if typ, err := typeFromName(name); err != nil {
+			t.Error(err)
+		} else if typ != expected {
+			t.Errorf("%s: expected %#v, got %#v", name, expected, typ)
+		}
+	}
+}
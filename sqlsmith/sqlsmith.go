@@ -1,18 +1,18 @@
 package sqlsmith
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"math/rand"
-	"strings"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"syscall"
 	"time"
-
-	"github.com/cockroachdb/cockroach/pkg/sql/parser"
-	// Import builtins for pretty printing.
-	_ "github.com/cockroachdb/cockroach/pkg/sql/sem/builtins"
-	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
-	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
 )
 
 // sqlsmith-go
@@ -44,60 +44,336 @@ import (
 // sqlsmith will simply try randomly to generate an expression, and once
 // it fails a certain number of times, it will retreat up the tree and
 // retry at a higher level.
+//
+// To waste fewer of those retries, productions declare what they need to
+// succeed (see feasible.go), and only those which can produce the requested
+// type in the current scope are picked between.
 
 const retryCount = 20
 
-func Run() {
-	rand.Seed(int64(time.Now().Nanosecond()))
+const defaultURL = "port=26257 user=root dbname=defaultdb sslmode=disable"
+
+// flushInterval is how often the output sinks are flushed during a run.
+const flushInterval = time.Second
+
+// Options configures a run of sqlsmith-go.
+type Options struct {
+	// AllowCodes and AllowErrors describe errors which are expected, and
+	// shouldn't be printed. AllowCodes is a list of SQLSTATE codes and
+	// AllowErrors a list of patterns matched against the error message.
+	// Internal errors are reported even if they match.
+	AllowCodes  []string
+	AllowErrors []*regexp.Regexp
+
+	// StatsInterval is how often statistics about the run are printed. If it
+	// is zero, they are only printed when the run ends.
+	StatsInterval time.Duration
+
+	// Duration is how long the run lasts. If it is zero, the run lasts until
+	// it's interrupted or finds a crash.
+	Duration time.Duration
+
+	// StatementTimeout is how long a statement may run before it's considered
+	// hung. If it is zero, statements may run forever.
+	StatementTimeout time.Duration
+
+	// Databases and Schemas are the databases and schemas tables are loaded
+	// from. If empty, the current database and the public schema are used.
+	// Virtual schemas, like pg_catalog or crdb_internal, can be included, and
+	// their tables are only read from.
+	Databases []string
+	Schemas   []string
+
+	// Weights are the weights of each production, as returned by MakeWeights.
+	// If nil, the defaults are used.
+	Weights map[string]int
+
+	// Budget bounds the size of each statement, as returned by MakeBudget.
+	// If nil, the default budget is used.
+	Budget *Budget
 
-	db, _ := sql.Open("postgres", "port=26257 user=root dbname=defaultdb sslmode=disable")
+	// Workers is the number of workers concurrently generating and executing
+	// statements, each on its own connection.
+	Workers int
+
+	// PopulateRows is the number of rows inserted into each table at the start
+	// of the run, and into each table the run creates, before statistics are
+	// created on it. If it is zero, tables aren't populated.
+	PopulateRows int
+
+	// Seed is the seed from which every worker's random decisions are derived.
+	// If it is zero, one is chosen based on the current time.
+	Seed int64
+
+	// SnapshotFile is the schema snapshot Generate generates statements
+	// against. It is either a JSON snapshot, as written by DumpSchema, or a
+	// .sql file of CREATE TABLE statements, whose operators and functions are
+	// the builtins.
+	SnapshotFile string
+
+	// LogDir, if set, is a directory in which each worker writes its output to
+	// its own file. Otherwise, workers write to stdout.
+	LogDir string
+
+	// JSONFile, CorpusFile and LogicTestFile, if set, are files the outcome of
+	// every statement executed during a run is written to. JSONFile gets a
+	// line of JSON for every statement, CorpusFile every statement which
+	// executed successfully, and LogicTestFile statement and query blocks in
+	// the format of Cockroach's logic tests.
+	JSONFile      string
+	CorpusFile    string
+	LogicTestFile string
+
+	// MutateFiles are corpus files, as read by LoadCorpus, whose statements
+	// are mutated and executed alongside generated ones.
+	MutateFiles []string
+
+	// CoverageCollector, if set, collects the code coverage of the server
+	// after each statement. Statements which reach new coverage are kept,
+	// written to CoverageCorpusFile if it's set, and mutated to make new
+	// statements.
+	CoverageCollector  CoverageCollector
+	CoverageCorpusFile string
+
+	// KeepGoing continues the run after the server crashes, once it has come
+	// back. RestartHook, if set, is called to bring it back, and
+	// ReconnectTimeout bounds how long we wait for it.
+	KeepGoing        bool
+	RestartHook      func() error
+	ReconnectTimeout time.Duration
+}
+
+func (opts Options) smitherOptions() ([]SmitherOption, error) {
+	var result []SmitherOption
+	if opts.Weights != nil {
+		result = append(result, Weights(opts.Weights))
+	}
+	if opts.Budget != nil {
+		result = append(result, SizeBudget(*opts.Budget))
+	}
+	if len(opts.MutateFiles) > 0 {
+		corpus, err := LoadCorpus(opts.MutateFiles...)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Corpus(corpus))
+	}
+	return result, nil
+}
+
+func Run(opts Options) {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	opts.Workers = workers
+	fmt.Printf("-- seed %d, %d workers\n", seed, workers)
+
+	db, _ := sql.Open("postgres", defaultURL)
 	defer db.Close()
 
-	schema := makeSchema(db)
+	schema, err := makeSchema(db, opts.Databases, opts.Schemas)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	smitherOpts, err := opts.smitherOptions()
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
 
-	for i := 0; ; i++ {
-		if i%100 == 0 {
-			create := sqlbase.RandCreateTable(schema.rnd, schema.rnd.Int())
-			stmt := pretty(create.String())
-			fmt.Println(stmt)
-			if _, err := db.Exec(stmt); err != nil {
+	out, err := openSinks(opts)
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			fmt.Println("error:", err)
+		}
+	}()
+
+	var g *guide
+	if opts.CoverageCollector != nil {
+		var corpus io.WriteCloser
+		if opts.CoverageCorpusFile != "" {
+			if corpus, err = os.Create(opts.CoverageCorpusFile); err != nil {
 				fmt.Println("error:", err)
+				return
+			}
+		}
+		if g, err = makeGuide(opts.CoverageCollector, corpus); err != nil {
+			fmt.Println("error:", err)
+			if corpus != nil {
+				_ = corpus.Close()
 			}
-			schema.ReloadSchemas()
+			return
 		}
+		defer func() {
+			if err := g.Close(); err != nil {
+				fmt.Println("error:", err)
+			}
+		}()
+		defer g.print(os.Stdout)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	r := &run{
+		ctx:     ctx,
+		stop:    stop,
+		opts:    opts,
+		db:      db,
+		schema:  schema,
+		allowed: makeAllowlist(opts.AllowCodes, opts.AllowErrors),
+		hc: &healthChecker{
+			db:               db,
+			pingTimeout:      10 * time.Second,
+			reconnectTimeout: opts.ReconnectTimeout,
+			restart:          opts.RestartHook,
+		},
+		stats:    makeStats(),
+		sinks:    out,
+		coverage: makeCoverage(),
+		guide:    g,
+	}
+	defer r.stats.print(os.Stdout)
+	defer r.coverage.print(os.Stdout, schema)
 
-		s := schema.makeScope()
-		sc, ok := s.makeStmt()
-		if !ok {
-			continue
+	// Each worker gets its own stream of random numbers, derived from the
+	// seed of the run so that the whole run can be reproduced.
+	seeds := rand.New(rand.NewSource(seed))
+	var stdoutMu sync.Mutex
+	// The workers are all set up before any starts, so there are none to stop
+	// if one can't be.
+	ws := make([]*worker, workers)
+	for i := range ws {
+		w := &worker{
+			run:  r,
+			id:   i,
+			seed: seeds.Int63(),
+			out:  os.Stdout,
 		}
-		expr := sc.expr
-		var buf bytes.Buffer
-		expr.Format(&buf)
-		stmt := pretty(buf.String())
-		fmt.Println(stmt)
-		fmt.Println()
-		rows, err := db.Query(stmt)
-		if err != nil {
-			if strings.Contains(err.Error(), "connection refused") {
-				// TODO(justin): we should dump the schema we used along with the panicking query in this case.
-				fmt.Println("panic!")
-				break
+		w.smither = newSmither(schema, rand.New(rand.NewSource(w.seed)), smitherOpts...)
+		w.smither.coverage = r.coverage
+		switch {
+		case opts.LogDir != "":
+			f, err := os.Create(filepath.Join(opts.LogDir, fmt.Sprintf("worker-%d.log", i)))
+			if err != nil {
+				fmt.Println("error:", err)
+				return
+			}
+			defer f.Close()
+			w.out = f
+		case workers > 1:
+			w.out = &prefixWriter{
+				mu:     &stdoutMu,
+				w:      os.Stdout,
+				prefix: []byte(fmt.Sprintf("w%d: ", i)),
 			}
-			fmt.Println()
-			fmt.Println("error:", err)
-			fmt.Println()
 		}
-		if err == nil {
-			_ = rows.Close()
+		ws[i] = w
+	}
+	var wg sync.WaitGroup
+	for _, w := range ws {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.loop()
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var tick <-chan time.Time
+	if opts.StatsInterval > 0 {
+		ticker := time.NewTicker(opts.StatsInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	// An interrupted run stops its workers, so the statistics and coverage
+	// are still printed and the sinks closed.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	var deadline <-chan time.Time
+	if opts.Duration > 0 {
+		timer := time.NewTimer(opts.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	// The sinks are flushed regularly so they're of use while the run is
+	// going, and aren't lost if it's killed.
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	for {
+		select {
+		case <-tick:
+			r.stats.print(os.Stdout)
+		case <-interrupt:
+			stop()
+		case <-deadline:
+			stop()
+		case <-flush.C:
+			if err := out.Flush(); err != nil {
+				fmt.Println("error:", err)
+			}
+		case <-done:
+			return
 		}
 	}
 }
 
-func pretty(sql string) string {
-	stmt, err := parser.ParseOne(sql)
+// DumpSchema writes a snapshot of the schema of the live database to w, which
+// can be used to generate statements offline with Generate. Tables are loaded
+// from opts.Databases and opts.Schemas.
+func DumpSchema(opts Options, w io.Writer) error {
+	db, err := sql.Open("postgres", defaultURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	schema, err := makeSchema(db, opts.Databases, opts.Schemas)
+	if err != nil {
+		return err
+	}
+	return schema.snapshot().write(w)
+}
+
+// Generate writes n statements generated against the schema snapshot in
+// opts.SnapshotFile to w, without a live database.
+func Generate(opts Options, n int, w io.Writer) error {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	smitherOpts, err := opts.smitherOptions()
 	if err != nil {
-		return sql
+		return err
+	}
+	smither, err := NewSmitherFromSnapshot(opts.SnapshotFile, rand.New(rand.NewSource(seed)), smitherOpts...)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "-- seed %d\n", seed); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		stmt, err := smither.GenerateStatement(AnyStatement)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s;\n\n", stmt); err != nil {
+			return err
+		}
 	}
-	return tree.Pretty(stmt.AST)
+	return nil
 }
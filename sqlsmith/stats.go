@@ -0,0 +1,131 @@
+package sqlsmith
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type productionStats struct {
+	attempts int
+	failures int
+}
+
+// stats tracks the outcomes of the statements executed during a run. It is
+// shared between workers.
+type stats struct {
+	mu sync.Mutex
+
+	start      time.Time
+	statements int
+	successes  int
+	findings   int
+	// retries counts retryable errors, which aren't counted as errors since
+	// they're an expected part of running transactions concurrently.
+	retries     int
+	errors      map[string]int
+	productions map[string]*productionStats
+}
+
+func makeStats() *stats {
+	return &stats{
+		start:       time.Now(),
+		errors:      make(map[string]int),
+		productions: make(map[string]*productionStats),
+	}
+}
+
+// productionName returns the name of the top-level production which
+// generated e.
+func productionName(e relExpr) string {
+	switch e := e.(type) {
+	case *ddl:
+		return strings.Replace(strings.TrimPrefix(e.production, "ddl."), "_", " ", -1)
+	case *insert:
+		return "insert"
+	case *selectExpr:
+		return "select"
+	case *values:
+		return "values"
+	case *setOp:
+		return "set op"
+	case *createView:
+		return "create view"
+	case *refreshView:
+		return "refresh view"
+	default:
+		return fmt.Sprintf("%T", e)
+	}
+}
+
+// record notes the result of executing a statement generated by production.
+// code is the error code the statement failed with, or empty if it was
+// successful.
+func (s *stats) record(production string, code string, class errorClass) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statements++
+	p, ok := s.productions[production]
+	if !ok {
+		p = &productionStats{}
+		s.productions[production] = p
+	}
+	p.attempts++
+	if code == "" {
+		s.successes++
+		return
+	}
+	p.failures++
+	if code == retryErrorCode {
+		s.retries++
+		return
+	}
+	s.errors[code]++
+	if class == errorInternal {
+		s.findings++
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *stats) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := time.Since(s.start)
+	rate := float64(s.statements) / elapsed.Seconds()
+	success := 0.0
+	if s.statements > 0 {
+		success = 100 * float64(s.successes) / float64(s.statements)
+	}
+	fmt.Fprintf(w, "-- stats after %s: %d statements (%.1f/s), %.1f%% successful, %d retries, %d findings\n",
+		elapsed.Round(time.Second), s.statements, rate, success, s.retries, s.findings)
+
+	codes := sortedKeys(s.errors)
+	sort.SliceStable(codes, func(i, j int) bool {
+		return s.errors[codes[i]] > s.errors[codes[j]]
+	})
+	for _, c := range codes {
+		fmt.Fprintf(w, "--   error %-8s %d\n", c, s.errors[c])
+	}
+
+	names := make([]string, 0, len(s.productions))
+	for n := range s.productions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		p := s.productions[n]
+		fmt.Fprintf(w, "--   %-10s %d attempts, %.1f%% failed\n",
+			n, p.attempts, 100*float64(p.failures)/float64(p.attempts))
+	}
+}
@@ -0,0 +1,114 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// restartSavepoint is the savepoint used by Cockroach's client-directed
+// transaction retry protocol.
+const restartSavepoint = "cockroach_restart"
+
+// generateTxn makes an explicit transaction: a BEGIN, a random sequence of
+// statements and savepoint operations, and a COMMIT or ROLLBACK.
+func (s *Smither) generateTxn() []statement {
+	s.schema.RLock()
+	defer s.schema.RUnlock()
+	return s.makeScope().makeTxn()
+}
+
+func (s *scope) makeTxn() []statement {
+	begin, readOnly := s.makeBegin()
+	result := []statement{{production: "begin", stmt: begin}}
+
+	// savepoints is the stack of savepoints which have been created and not
+	// yet released.
+	var savepoints []string
+	for n := 1 + s.rnd.Intn(10); n > 0; n-- {
+		candidates := []string{"txn.stmt", "txn.savepoint"}
+		if len(savepoints) > 0 {
+			candidates = append(candidates, "txn.rollback_to", "txn.release")
+		}
+		switch s.pick(candidates...) {
+		case "txn.stmt":
+			// Each statement has a budget of its own, like one outside a
+			// transaction. Statements in a read-only transaction can't
+			// modify data.
+			stmtScope := s.Smither.makeScope()
+			stmtScope.readOnly = readOnly
+			if expr, ok := stmtScope.makeStmtOfKind(AnyStatement); ok {
+				result = append(result, s.makeStatement(expr))
+			}
+		case "txn.savepoint":
+			name := s.uniqueName("sp")
+			// The restart savepoint must be the first statement of the
+			// transaction.
+			if len(result) == 1 && s.chance("txn.restart_savepoint") {
+				name = restartSavepoint
+			}
+			savepoints = append(savepoints, name)
+			result = append(result, statement{production: "savepoint", stmt: "savepoint " + name})
+		case "txn.rollback_to":
+			i := s.rnd.Intn(len(savepoints))
+			result = append(result, statement{
+				production: "rollback to savepoint",
+				stmt:       "rollback to savepoint " + savepoints[i],
+			})
+			savepoints = savepoints[:i+1]
+		case "txn.release":
+			i := s.rnd.Intn(len(savepoints))
+			result = append(result, statement{
+				production: "release savepoint",
+				stmt:       "release savepoint " + savepoints[i],
+			})
+			savepoints = savepoints[:i]
+		}
+	}
+
+	end := "commit"
+	if s.pick("txn.commit", "txn.rollback") == "txn.rollback" {
+		end = "rollback"
+	}
+	result = append(result, statement{production: end, stmt: end})
+	return result
+}
+
+// makeBegin returns a BEGIN with random options, and whether the
+// transaction it starts is read-only.
+func (s *scope) makeBegin() (string, bool) {
+	var opts []string
+	if s.chance("txn.isolation") {
+		if s.coin() {
+			opts = append(opts, "isolation level serializable")
+		} else {
+			opts = append(opts, "isolation level snapshot")
+		}
+	}
+	if s.chance("txn.priority") {
+		opts = append(opts, "priority "+[]string{"low", "normal", "high"}[s.rnd.Intn(3)])
+	}
+	readOnly := false
+	if s.chance("txn.read_only") {
+		readOnly = true
+		opts = append(opts, "read only")
+	}
+	if s.chance("txn.as_of") {
+		// Historical transactions can't write.
+		readOnly = true
+		opts = append(opts, fmt.Sprintf("as of system time '-%dms'", 1+s.rnd.Intn(1000)))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("begin transaction")
+	if len(opts) > 0 {
+		buf.WriteByte(' ')
+		buf.WriteString(strings.Join(opts, ", "))
+	}
+	return buf.String(), readOnly
+}
+
+// isRestart returns whether stmt creates the restart savepoint.
+func isRestart(stmt string) bool {
+	return stmt == "savepoint "+restartSavepoint
+}
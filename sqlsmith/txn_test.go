@@ -0,0 +1,76 @@
+package sqlsmith
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestGenerateTxnSavepoints(t *testing.T) {
+	schema, err := loadSnapshot(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Savepoints are made more likely than statements, so every operation is
+	// exercised.
+	weights, err := MakeWeights("", "", "txn.stmt=1,txn.savepoint=3,txn.rollback_to=3,txn.release=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for seed := int64(0); seed < numSeeds; seed++ {
+		s := newSmither(schema, rand.New(rand.NewSource(seed)), Weights(weights))
+		txn := s.generateTxn()
+		if first := txn[0].stmt; !strings.HasPrefix(first, "begin transaction") {
+			t.Errorf("seed %d: transaction starts with %s", seed, first)
+		}
+		if last := txn[len(txn)-1].stmt; last != "commit" && last != "rollback" {
+			t.Errorf("seed %d: transaction ends with %s", seed, last)
+		}
+		// savepoints is the stack of savepoints the server has at each point of
+		// the transaction.
+		var savepoints []string
+		find := func(name string) int {
+			for i, sp := range savepoints {
+				if sp == name {
+					return i
+				}
+			}
+			return -1
+		}
+		for i, st := range txn[1 : len(txn)-1] {
+			seen[st.production] = true
+			switch st.production {
+			case "savepoint":
+				if isRestart(st.stmt) {
+					seen["restart"] = true
+					if i != 0 {
+						t.Errorf("seed %d: restart savepoint is statement %d of the transaction", seed, i+1)
+					}
+				}
+				savepoints = append(savepoints, strings.TrimPrefix(st.stmt, "savepoint "))
+			case "rollback to savepoint":
+				j := find(strings.TrimPrefix(st.stmt, "rollback to savepoint "))
+				if j < 0 {
+					t.Errorf("seed %d: %s with savepoints %v", seed, st.stmt, savepoints)
+					continue
+				}
+				// Rolling back to a savepoint keeps it, but discards those made
+				// after it.
+				savepoints = savepoints[:j+1]
+			case "release savepoint":
+				j := find(strings.TrimPrefix(st.stmt, "release savepoint "))
+				if j < 0 {
+					t.Errorf("seed %d: %s with savepoints %v", seed, st.stmt, savepoints)
+					continue
+				}
+				savepoints = savepoints[:j]
+			}
+		}
+	}
+	for _, p := range []string{"savepoint", "rollback to savepoint", "release savepoint", "restart"} {
+		if !seen[p] {
+			t.Errorf("no transaction had a %s", p)
+		}
+	}
+}
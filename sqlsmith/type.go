@@ -2,38 +2,146 @@ package sqlsmith
 
 import (
 	"fmt"
-	"math/rand"
 	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/lib/pq/oid"
 )
 
+// anyType is the pseudo-type "any". Unlike anyelement, which is types.Any,
+// each argument of a function declared as taking "any" may be of a different
+// type.
+type anyType struct {
+	types.T
+}
+
+func (anyType) String() string {
+	return "any"
+}
+
+var anyPseudoType types.T = anyType{types.Any}
+
+// polymorphicTypes are the pseudo-types function arguments and results may
+// be declared with. They're instantiated with concrete types when a call is
+// generated.
+var polymorphicTypes = map[oid.Oid]types.T{
+	oid.T_any:         anyPseudoType,
+	oid.T_anyelement:  types.Any,
+	oid.T_anynonarray: types.Any,
+	oid.T_anyarray:    types.AnyArray,
+}
+
+// isPolymorphic returns whether typ is one of the polymorphicTypes.
+func isPolymorphic(typ types.T) bool {
+	return typ == types.Any || typ == types.AnyArray || typ == anyPseudoType
+}
+
+// funcType returns the type of a function argument or result with the given
+// oid, which may be polymorphic.
+func funcType(o oid.Oid) (types.T, bool) {
+	if typ, ok := polymorphicTypes[o]; ok {
+		return typ, true
+	}
+	return knownType(o)
+}
+
+// knownType returns the type with the given oid, if we can generate values of
+// it. Records, which are tuples of any shape, can't be.
+func knownType(o oid.Oid) (types.T, bool) {
+	if o == oid.T_record {
+		return nil, false
+	}
+	typ, ok := types.OidToType[o]
+	return typ, ok
+}
+
 var typeNames = func() map[string]types.T {
 	m := map[string]types.T{
 		"int8":   types.Int,
 		"int8[]": types.TArray{Typ: types.Int},
 		"float8": types.Float,
 	}
+	// Several oids share a name, so name the unwrapped types first, which
+	// agree whatever order the map is ranged in, and only then the names
+	// which are peculiar to a wrapper, such as "name".
 	for _, T := range types.OidToType {
+		T = unwrapType(T)
 		m[T.SQLName()] = T
 		m[T.String()] = T
 	}
+	for _, T := range types.OidToType {
+		for _, name := range []string{T.SQLName(), T.String()} {
+			if _, ok := m[name]; !ok {
+				m[name] = T
+			}
+		}
+	}
+	m["any"] = anyPseudoType
+	m["anyelement"] = types.Any
+	m["anyarray"] = types.AnyArray
+	m[types.AnyArray.String()] = types.AnyArray
 	return m
 }()
 
-func typeFromName(name string) types.T {
-	// Fill in any collated string type names we see.
+// unwrapType strips the oid wrappers from typ and from its element type,
+// if it's an array.
+func unwrapType(typ types.T) types.T {
+	typ = types.UnwrapType(typ)
+	if arr, ok := typ.(types.TArray); ok {
+		return types.TArray{Typ: unwrapType(arr.Typ)}
+	}
+	return typ
+}
+
+// lookupType returns the type with the given name, if we know of it.
+func lookupType(name string) (types.T, bool) {
+	// Collated string types are built as we see them, rather than added to
+	// typeNames, since schemas may be loaded concurrently.
 	if sp := strings.Split(name, "STRING COLLATE "); len(sp) == 2 {
-		typeNames[strings.ToLower(name)] = types.TCollatedString{Locale: sp[1]}
+		return types.TCollatedString{Locale: sp[1]}, true
 	}
 	typ, ok := typeNames[strings.ToLower(name)]
+	return typ, ok
+}
+
+// typeFromName returns the type with the given name, or an error if we
+// don't know of it.
+func typeFromName(name string) (types.T, error) {
+	typ, ok := lookupType(name)
 	if !ok {
-		panic(fmt.Errorf("unknown type name: %s", name))
+		return nil, fmt.Errorf("unknown type name: %s", name)
 	}
-	return typ
+	return typ, nil
+}
+
+// castFamilies are groups of types which can be cast to one another without
+// the cast failing outright.
+var castFamilies = [][]types.T{
+	{types.Int, types.Float, types.Decimal},
+	{types.Date, types.Timestamp, types.TimestampTZ},
+	{types.Time, types.Interval},
+}
+
+// isCastable returns whether a value of type from can be cast to type to.
+// Anything can be cast to a string.
+func isCastable(from, to types.T) bool {
+	if to == types.String || from.FamilyEqual(to) {
+		return true
+	}
+	for _, family := range castFamilies {
+		var hasFrom, hasTo bool
+		for _, typ := range family {
+			hasFrom = hasFrom || typ == from
+			hasTo = hasTo || typ == to
+		}
+		if hasFrom && hasTo {
+			return true
+		}
+	}
+	return false
 }
 
-func getRandType() types.T {
+func (s *scope) randType() types.T {
 	arr := types.AnyNonArray
-	return arr[rand.Intn(len(arr))]
+	return arr[s.rnd.Intn(len(arr))]
 }
@@ -0,0 +1,187 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/lex"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+)
+
+func (s *scope) makeView() (*scope, bool) {
+	candidates := []string{"view.create", "view.create_materialized"}
+	for _, t := range s.schema.tables {
+		if t.isMaterialized {
+			candidates = append(candidates, "view.refresh")
+			break
+		}
+	}
+	production := s.pick(candidates...)
+	var outScope *scope
+	var ok bool
+	switch production {
+	case "view.create":
+		outScope, ok = s.makeCreateView(false /* materialized */)
+	case "view.create_materialized":
+		outScope, ok = s.makeCreateView(true /* materialized */)
+	case "view.refresh":
+		outScope, ok = s.makeRefreshView()
+	}
+	if !ok {
+		s.coverage.fail(production)
+	}
+	return outScope, ok
+}
+
+//////////////
+// CREATE VIEW
+//////////////
+
+type createView struct {
+	name         string
+	materialized bool
+	cols         []string
+	body         relExpr
+}
+
+func (s *scope) makeCreateView(materialized bool) (*scope, bool) {
+	outScope := s.push()
+
+	var desiredTypes []types.T
+	for {
+		desiredTypes = append(desiredTypes, s.randType())
+		if s.d6() < 3 {
+			break
+		}
+	}
+
+	// Views can't modify data.
+	bodyScope := s.push()
+	bodyScope.readOnly = true
+	body, ok := bodyScope.makeReturningStmt(desiredTypes)
+	if !ok {
+		return nil, false
+	}
+
+	// Name the columns explicitly, since the body may well have several
+	// columns with the same name.
+	cols := make([]string, len(desiredTypes))
+	for i := range cols {
+		cols[i] = fmt.Sprintf("col%d", i)
+	}
+	prefix := "view"
+	if materialized {
+		prefix = "matview"
+	}
+
+	outScope.expr = &createView{
+		name:         s.uniqueName(prefix),
+		materialized: materialized,
+		cols:         cols,
+		body:         body.expr,
+	}
+	return outScope, true
+}
+
+func (c *createView) Format(buf *bytes.Buffer) {
+	buf.WriteString("create ")
+	if c.materialized {
+		buf.WriteString("materialized ")
+	}
+	fmt.Fprintf(buf, "view %s (%s) as ", c.name, strings.Join(c.cols, ", "))
+	c.body.Format(buf)
+}
+
+func (c *createView) Cols() []column {
+	return nil
+}
+
+////////////////////////////
+// REFRESH MATERIALIZED VIEW
+////////////////////////////
+
+type refreshView struct {
+	name string
+}
+
+func (s *scope) makeRefreshView() (*scope, bool) {
+	var views []table
+	for _, t := range s.schema.tables {
+		if t.isMaterialized {
+			views = append(views, t)
+		}
+	}
+	if len(views) == 0 {
+		return nil, false
+	}
+	outScope := s.push()
+	outScope.expr = &refreshView{
+		name: views[s.rnd.Intn(len(views))].qualifiedName(),
+	}
+	return outScope, true
+}
+
+func (r *refreshView) Format(buf *bytes.Buffer) {
+	buf.WriteString("refresh materialized view ")
+	buf.WriteString(r.name)
+}
+
+func (r *refreshView) Cols() []column {
+	return nil
+}
+
+///////////
+// SEQUENCE
+///////////
+
+type sequenceExpr struct {
+	fn  string
+	seq string
+	// value is the value passed to setval.
+	value scalarExpr
+}
+
+func (e *sequenceExpr) Type() types.T {
+	return types.Int
+}
+
+func (e *sequenceExpr) Format(buf *bytes.Buffer) {
+	buf.WriteString(e.fn)
+	buf.WriteByte('(')
+	lex.EncodeSQLString(buf, e.seq)
+	if e.value != nil {
+		buf.WriteString(", ")
+		e.value.Format(buf)
+	}
+	buf.WriteByte(')')
+}
+
+// makeSequenceExpr makes a call to one of the sequence functions on a random
+// sequence. Only currval is called if we can't modify data.
+func (s *scope) makeSequenceExpr() (scalarExpr, bool) {
+	if len(s.schema.sequences) == 0 {
+		return nil, false
+	}
+	seq := s.schema.sequences[s.rnd.Intn(len(s.schema.sequences))]
+	out := &sequenceExpr{
+		fn:  "currval",
+		seq: seq.qualifiedName(),
+	}
+	if !s.canMutate() {
+		return out, true
+	}
+	switch s.pick("sequence.nextval", "sequence.currval", "sequence.setval") {
+	case "sequence.nextval":
+		out.fn = "nextval"
+	case "sequence.setval":
+		out.fn = "setval"
+		value, ok := s.makeScalar(types.Int)
+		if !ok {
+			s.coverage.fail("sequence.setval")
+			return nil, false
+		}
+		out.value = value
+	}
+	return out, true
+}
@@ -0,0 +1,321 @@
+package sqlsmith
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultWeights are the weights of every production sqlsmith-go chooses
+// between. They're named after the choice they're part of: when generating,
+// say, a scalar expression, one of the possible "scalar." productions is
+// picked with probability proportional to its weight.
+//
+// The exceptions are optional parts of a production, like a WHERE clause,
+// whose weight is the percentage chance of them being generated.
+var defaultWeights = map[string]int{
+	// Statements.
+	"stmt.insert":    10,
+	"stmt.returning": 20,
+	"stmt.ddl":       1,
+
+	// Statements which return rows. Set operations are off by default, since
+	// they fail semantic analysis too often.
+	"returning.select": 5,
+	"returning.values": 1,
+	"returning.setop":  0,
+
+	// Data sources in a FROM clause.
+	"source.table":            4,
+	"source.join":             2,
+	"source.insert_returning": 1,
+
+	// The percentage chance of a table data source forcing one of its
+	// indexes.
+	"source.index_hint": 10,
+
+	// Join conditions. Those on foreign keys and indexes fall back to
+	// arbitrary expressions if there are no suitable keys or indexes.
+	"join.on_fk":    2,
+	"join.on_index": 2,
+	"join.on_expr":  4,
+
+	// Optional parts of a SELECT, as percentages.
+	"select.where":    50,
+	"select.distinct": 1,
+	"select.limit":    67,
+
+	// The percentage chance of an INSERT writing a nullable column, having an
+	// ON CONFLICT clause, and that clause being DO UPDATE rather than DO
+	// NOTHING.
+	"insert.nullable":           50,
+	"insert.on_conflict":        20,
+	"insert.on_conflict_update": 50,
+
+	// Scalar expressions.
+	"scalar.case":     2,
+	"scalar.coalesce": 1,
+	"scalar.colref":   20,
+	"scalar.binop":    3,
+	"scalar.func":     3,
+	"scalar.subquery": 1,
+	"scalar.const":    4,
+	"scalar.sequence": 1,
+
+	// The percentage chance of a scalar subquery being given LIMIT 1, so it
+	// can't return more than one row. Those without it make the statement
+	// fail whenever they do, which wastes the statement, but they're the
+	// only ones check.subquery can check.
+	"scalar.subquery_limit": 80,
+
+	// Calls to sequence functions, which are only made when an INT is wanted.
+	"sequence.nextval": 4,
+	"sequence.currval": 1,
+	"sequence.setval":  1,
+
+	// Periodic view statements.
+	"view.create":              4,
+	"view.create_materialized": 2,
+	"view.refresh":             2,
+
+	// Boolean expressions, such as those in WHERE and ON clauses.
+	"bool.binop":  3,
+	"bool.scalar": 2,
+	"bool.exists": 1,
+
+	// The percentage chance of a statement being replaced by an explicit
+	// transaction, and the statements and savepoint operations making up
+	// the transaction.
+	"txn.explicit":    5,
+	"txn.stmt":        6,
+	"txn.savepoint":   1,
+	"txn.rollback_to": 1,
+	"txn.release":     1,
+	"txn.commit":      3,
+	"txn.rollback":    1,
+
+	// The percentage chance of each BEGIN option, and of the first savepoint
+	// in a transaction being the restart savepoint, which allows the
+	// transaction to be retried.
+	"txn.isolation":         25,
+	"txn.priority":          25,
+	"txn.read_only":         10,
+	"txn.as_of":             5,
+	"txn.restart_savepoint": 50,
+
+	// The percentage chance of a statement being prepared and executed with
+	// random arguments, of each constant in it being replaced by a
+	// placeholder, and of a placeholder being cast to its type rather than
+	// having its type inferred.
+	"prepare.statement":   5,
+	"prepare.placeholder": 30,
+	"prepare.cast":        50,
+
+	// Distributions of the values of each column when populating a table.
+	"populate.uniform":    4,
+	"populate.null_heavy": 2,
+	"populate.duplicates": 2,
+	"populate.boundary":   2,
+
+	// Schema changes.
+	"ddl.add_column":          4,
+	"ddl.add_computed_column": 2,
+	"ddl.drop_column":         2,
+	"ddl.alter_column":        2,
+	"ddl.create_index":        4,
+	"ddl.drop_index":          2,
+	"ddl.add_constraint":      3,
+	"ddl.drop_constraint":     2,
+	"ddl.rename":              2,
+	"ddl.truncate":            1,
+	"ddl.drop_table":          1,
+
+	// The variants of the schema changes above.
+	"ddl.alter_column.set_default":   3,
+	"ddl.alter_column.drop_default":  2,
+	"ddl.alter_column.drop_not_null": 1,
+	"ddl.add_constraint.unique":      2,
+	"ddl.add_constraint.check":       2,
+	"ddl.add_constraint.foreign_key": 2,
+	"ddl.rename.table":               2,
+	"ddl.rename.column":              3,
+	"ddl.rename.index":               1,
+
+	// The percentage chance of a new index being inverted, unique, storing
+	// extra columns and partial. Inverted indexes are only created on tables
+	// with JSONB or array columns.
+	"ddl.index_inverted": 20,
+	"ddl.index_unique":   25,
+	"ddl.index_storing":  25,
+	"ddl.index_partial":  10,
+
+	// The percentage chance of a statement being a mutation of one which
+	// reached new coverage, when the run is guided by coverage, or else of one
+	// from the corpus, when there is a corpus, rather than generated from
+	// scratch; and the mutations applied to it.
+	"mutate.guided":    50,
+	"mutate.corpus":    50,
+	"mutate.expr":      4,
+	"mutate.table":     2,
+	"mutate.predicate": 3,
+	"mutate.join":      1,
+
+	// The percentage chance of the uncorrelated scalar subqueries without
+	// LIMIT 1 in a statement which succeeded being executed on their own, to
+	// check they return at most one row. Only done with a single worker.
+	"check.subquery": 10,
+}
+
+// profiles are preset weights, applied on top of the defaults, aimed at
+// exercising a particular part of the system.
+var profiles = map[string]map[string]int{
+	"default": {},
+	"mutations-heavy": {
+		"stmt.insert":             60,
+		"stmt.returning":          10,
+		"source.insert_returning": 4,
+		"insert.nullable":         80,
+		"insert.on_conflict":      50,
+	},
+	"joins-heavy": {
+		"stmt.insert":             0,
+		"stmt.ddl":                0,
+		"returning.values":        0,
+		"source.table":            2,
+		"source.join":             6,
+		"source.insert_returning": 0,
+		"source.index_hint":       25,
+		"join.on_fk":              4,
+		"join.on_index":           4,
+		"scalar.colref":           30,
+		"bool.binop":              4,
+		"bool.exists":             2,
+	},
+	"ddl-heavy": {
+		"stmt.insert":    10,
+		"stmt.returning": 10,
+		"stmt.ddl":       10,
+	},
+	"scalar-only": {
+		"stmt.insert":             0,
+		"stmt.ddl":                0,
+		"returning.select":        1,
+		"returning.values":        4,
+		"source.join":             0,
+		"source.insert_returning": 0,
+		"scalar.case":             4,
+		"scalar.coalesce":         4,
+		"scalar.binop":            8,
+		"scalar.func":             8,
+		"scalar.subquery":         0,
+		"bool.exists":             0,
+	},
+}
+
+// Profiles returns the names of the preset weight profiles.
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for n := range profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func setWeight(w map[string]int, name string, weight int) error {
+	if _, ok := defaultWeights[name]; !ok {
+		return fmt.Errorf("unknown production %q", name)
+	}
+	if weight < 0 {
+		return fmt.Errorf("negative weight for production %q", name)
+	}
+	w[name] = weight
+	return nil
+}
+
+// MakeWeights returns the weights of each production, starting from the
+// defaults and applying, in order, the named profile, the JSON object of
+// weights in the file at path, and overrides, which is a comma-separated list
+// of name=weight pairs. Any of them may be empty.
+func MakeWeights(profile, path, overrides string) (map[string]int, error) {
+	w := make(map[string]int, len(defaultWeights))
+	for n, v := range defaultWeights {
+		w[n] = v
+	}
+	if profile != "" {
+		p, ok := profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q, expected one of %s",
+				profile, strings.Join(Profiles(), ", "))
+		}
+		for n, v := range p {
+			w[n] = v
+		}
+	}
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var fromFile map[string]int
+		if err := json.Unmarshal(contents, &fromFile); err != nil {
+			return nil, fmt.Errorf("loading %s: %v", path, err)
+		}
+		for n, v := range fromFile {
+			if err := setWeight(w, n, v); err != nil {
+				return nil, fmt.Errorf("loading %s: %v", path, err)
+			}
+		}
+	}
+	if overrides != "" {
+		for _, o := range strings.Split(overrides, ",") {
+			kv := strings.SplitN(o, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("expected name=weight, found %q", o)
+			}
+			v, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight in %q: %v", o, err)
+			}
+			if err := setWeight(w, strings.TrimSpace(kv[0]), v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return w, nil
+}
+
+// pick returns one of the productions in names, with probability
+// proportional to its weight. It returns the empty string if they all have a
+// weight of zero.
+func (s *scope) pick(names ...string) string {
+	total := 0
+	for _, n := range names {
+		total += s.weights[n]
+	}
+	if total == 0 {
+		return ""
+	}
+	r := s.rnd.Intn(total)
+	for _, n := range names {
+		r -= s.weights[n]
+		if r < 0 {
+			s.coverage.attempt(n)
+			return n
+		}
+	}
+	panic("unreachable")
+}
+
+// chance returns true with the percentage chance given by the weight of the
+// optional production name.
+func (s *scope) chance(name string) bool {
+	if s.rnd.Intn(100) < s.weights[name] {
+		s.coverage.attempt(name)
+		return true
+	}
+	return false
+}
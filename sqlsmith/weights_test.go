@@ -0,0 +1,94 @@
+package sqlsmith
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	for name, p := range profiles {
+		for n := range p {
+			if _, ok := defaultWeights[n]; !ok {
+				t.Errorf("profile %s: unknown production %q", name, n)
+			}
+		}
+	}
+}
+
+func TestMakeWeights(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valid := write("valid.json", `{"stmt.insert": 7, "scalar.case": 0}`)
+	unknown := write("unknown.json", `{"stmt.nothing": 1}`)
+	malformed := write("malformed.json", `{"stmt.insert": "many"}`)
+
+	for _, tc := range []struct {
+		name                     string
+		profile, path, overrides string
+		expected                 map[string]int
+		err                      string
+	}{
+		{
+			name:     "defaults",
+			expected: map[string]int{"stmt.insert": 10, "scalar.case": 2},
+		},
+		{
+			name:     "profile",
+			profile:  "mutations-heavy",
+			expected: map[string]int{"stmt.insert": 60, "scalar.case": 2},
+		},
+		{
+			name:     "file over profile",
+			profile:  "mutations-heavy",
+			path:     valid,
+			expected: map[string]int{"stmt.insert": 7, "scalar.case": 0, "insert.on_conflict": 50},
+		},
+		{
+			name:      "overrides over file",
+			path:      valid,
+			overrides: " stmt.insert = 3 ,scalar.func=0",
+			expected:  map[string]int{"stmt.insert": 3, "scalar.case": 0, "scalar.func": 0},
+		},
+		{name: "unknown profile", profile: "everything", err: `unknown profile "everything"`},
+		{name: "missing file", path: filepath.Join(dir, "missing.json"), err: "no such file"},
+		{name: "unknown production in file", path: unknown, err: `unknown production "stmt.nothing"`},
+		{name: "malformed file", path: malformed, err: "loading " + malformed},
+		{name: "unknown override", overrides: "stmt.nothing=1", err: `unknown production "stmt.nothing"`},
+		{name: "negative override", overrides: "stmt.insert=-1", err: `negative weight for production "stmt.insert"`},
+		{name: "override without weight", overrides: "stmt.insert", err: `expected name=weight, found "stmt.insert"`},
+		{name: "invalid override", overrides: "stmt.insert=x", err: `invalid weight in "stmt.insert=x"`},
+	} {
+		w, err := MakeWeights(tc.profile, tc.path, tc.overrides)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(w) != len(defaultWeights) {
+			t.Errorf("%s: %d weights, expected %d", tc.name, len(w), len(defaultWeights))
+		}
+		for n, v := range tc.expected {
+			if w[n] != v {
+				t.Errorf("%s: weight of %s is %d, expected %d", tc.name, n, w[n], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package sqlsmith
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+)
+
+// run is the state shared between all the workers of a single run.
+type run struct {
+	ctx context.Context
+	// stop ends the run, once every worker notices.
+	stop func()
+
+	opts     Options
+	db       *sql.DB
+	schema   *schema
+	allowed  allowlist
+	hc       *healthChecker
+	stats    *stats
+	sinks    sinks
+	coverage *coverage
+	// guide, if set, guides the run by the server's code coverage.
+	guide *guide
+}
+
+// worker generates and executes statements on its own connection, making
+// all of its random decisions from its own seed.
+type worker struct {
+	*run
+
+	id      int
+	seed    int64
+	smither *Smither
+	conn    *sql.Conn
+	out     io.Writer
+	// index is the number of statements the worker has executed.
+	index int
+}
+
+func (w *worker) printf(format string, args ...interface{}) {
+	// Write each message in a single call so that messages from different
+	// workers sharing an output don't interleave.
+	_, _ = io.WriteString(w.out, fmt.Sprintf(format, args...))
+}
+
+func (w *worker) connect() error {
+	if w.conn != nil {
+		_ = w.conn.Close()
+	}
+	conn, err := w.db.Conn(w.ctx)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *worker) loop() {
+	defer func() {
+		if w.conn != nil {
+			_ = w.conn.Close()
+		}
+	}()
+	w.printf("-- worker %d, seed %d\n", w.id, w.seed)
+	if err := w.connect(); err != nil {
+		w.printf("error: %v\n", err)
+		return
+	}
+
+	// The workers split populating the existing tables between them.
+	for i, t := range w.schema.populatable(nil) {
+		if i%w.opts.Workers == w.id && !w.populate(t) {
+			return
+		}
+	}
+
+	for i := 0; w.ctx.Err() == nil; i++ {
+		if i%100 == 0 {
+			rnd := w.smither.rnd
+			create := sqlbase.RandCreateTable(rnd, rnd.Int())
+			if stmt, err := pretty(create.String()); err != nil {
+				w.reportBug(statement{production: "create table", bug: err})
+			} else {
+				w.printf("%s\n", stmt)
+				if err := w.exec(stmt); err != nil {
+					w.printf("error: %v\n", err)
+				}
+				if err := w.schema.ReloadSchemas(); err != nil {
+					w.printf("error: %v\n", err)
+				}
+				for _, t := range w.schema.populatable(map[string]bool{create.Table.Table(): true}) {
+					if !w.populate(t) {
+						return
+					}
+				}
+			}
+		}
+
+		if i%100 == 50 && !w.smither.disableMutations {
+			if expr, ok := w.smither.generate(ViewStatement); ok {
+				if keepGoing, _ := w.execute(w.smither.makeStatement(expr)); !keepGoing {
+					return
+				}
+			}
+		}
+
+		if !w.step() {
+			return
+		}
+	}
+}
+
+// populate inserts opts.PopulateRows rows into t and then creates statistics
+// on it, so queries against it have something to work with. Batches which fail,
+// usually because a row violates one of the table's constraints, are retried
+// a row at a time to skip the offending rows. It returns false if the worker
+// should stop.
+func (w *worker) populate(t table) bool {
+	p, ok := w.smither.makeScope().makePopulation(t, w.opts.PopulateRows)
+	if !ok {
+		return true
+	}
+	w.printf("-- populating %s\n", t.qualifiedName())
+	for _, batch := range p.batches {
+		ok, keepGoing := w.execPopulate(p.insertStmt(batch))
+		if !keepGoing {
+			return false
+		}
+		if ok || len(batch) == 1 {
+			continue
+		}
+		for _, row := range batch {
+			if _, keepGoing := w.execPopulate(p.insertStmt([]string{row})); !keepGoing {
+				return false
+			}
+		}
+	}
+	_, keepGoing := w.execPopulate(p.stats)
+	return keepGoing
+}
+
+// execPopulate executes a statement populating a table like execute,
+// returning whether it succeeded, and false if the worker should stop.
+func (w *worker) execPopulate(stmt string) (ok, keepGoing bool) {
+	st := statement{production: "populate", stmt: stmt, populate: true}
+	keepGoing, err := w.do(st, func(ctx context.Context) (*sql.Rows, error) {
+		_, err := w.conn.ExecContext(ctx, stmt)
+		return nil, err
+	})
+	return err == nil, keepGoing
+}
+
+// exec executes stmt, which sets up the database rather than being generated
+// to test it, and writes its outcome to the sinks.
+func (w *worker) exec(stmt string) error {
+	start := time.Now()
+	_, err := w.conn.ExecContext(w.ctx, stmt)
+	e := execution{stmt: stmt, duration: time.Since(start)}
+	if err != nil {
+		e.code = errorCode(err)
+	}
+	w.emit(e)
+	return err
+}
+
+// emit writes the outcome of executing a statement to the sinks.
+func (w *worker) emit(e execution) {
+	e.worker = w.id
+	e.seed = w.seed
+	e.index = w.index
+	w.index++
+	if err := w.sinks.record(e); err != nil {
+		w.printf("error: %v\n", err)
+	}
+	if w.guide != nil {
+		w.observe(e)
+	}
+}
+
+// observe collects coverage after e, keeping its statement if it was
+// generated and reached new points. The coverage reached by other
+// statements, like those setting up the database, is only marked as seen,
+// so it isn't credited to the next generated statement.
+func (w *worker) observe(e execution) {
+	// Prepared statements can't be mutated without their arguments.
+	if !e.generated || e.args != nil || e.prepare {
+		if _, err := w.guide.update(); err != nil {
+			w.printf("error: %v\n", err)
+		}
+		return
+	}
+	gain, err := w.guide.observe(e.stmt)
+	if err != nil {
+		w.printf("error: %v\n", err)
+	} else if gain > 0 {
+		w.printf("-- reached %d new coverage points\n\n", gain)
+	}
+}
+
+// step generates and executes a single statement, or sometimes an explicit
+// transaction or a prepared statement. It returns false if the worker should stop.
+func (w *worker) step() bool {
+	sc := w.smither.makeScope()
+	if sc.chance("txn.explicit") {
+		return w.stepTxn()
+	}
+	if sc.chance("prepare.statement") {
+		return w.stepPrepared()
+	}
+	if w.guide != nil && sc.chance("mutate.guided") {
+		if stmt, ok := w.guide.pick(w.smither.rnd); ok {
+			st, ok := w.smither.makeMutation(stmt)
+			if !ok {
+				return true
+			}
+			keepGoing, _ := w.execute(st)
+			return keepGoing
+		}
+	}
+	if len(w.smither.corpus) > 0 && sc.chance("mutate.corpus") {
+		st, ok := w.smither.generateMutation()
+		if !ok {
+			return true
+		}
+		keepGoing, _ := w.execute(st)
+		return keepGoing
+	}
+	expr, ok := w.smither.generate(AnyStatement)
+	if !ok {
+		return true
+	}
+	keepGoing, err := w.execute(w.smither.makeStatement(expr))
+	// Other workers may change the data between the statement and its
+	// subqueries.
+	if !keepGoing || err != nil || w.opts.Workers != 1 || !sc.chance("check.subquery") {
+		return keepGoing
+	}
+	return w.checkSubqueries(expr)
+}
+
+// checkSubqueries executes the uncorrelated scalar subqueries without LIMIT 1
+// in expr on their own. Since the statement succeeded, they should return at
+// most one row, though the subquery may have been optimized away, or not
+// evaluated for the row which would have failed, so more rows are only noted
+// rather than reported as a finding. It returns false if the worker should
+// stop.
+func (w *worker) checkSubqueries(expr relExpr) bool {
+	for _, sub := range scalarSubqueries(expr) {
+		st := w.smither.makeStatement(sub)
+		st.production = "scalar subquery"
+		st.scalar = true
+		if keepGoing, _ := w.execute(st); !keepGoing {
+			return false
+		}
+	}
+	return true
+}
+
+// maxTxnRetries is the number of times a transaction using the restart
+// savepoint is retried after a retryable error.
+const maxTxnRetries = 3
+
+// stepTxn generates and executes an explicit transaction. Retryable errors
+// are retried from the restart savepoint, if the transaction has one, and
+// any other error rolls the transaction back. It returns false if the worker
+// should stop.
+func (w *worker) stepTxn() bool {
+	txn := w.smither.generateTxn()
+	// restart is the index of the statement creating the restart savepoint,
+	// once it's been executed.
+	restart := -1
+	retries := 0
+	for i := 0; i < len(txn); i++ {
+		keepGoing, err := w.execute(txn[i])
+		if !keepGoing {
+			return false
+		}
+		if err == nil {
+			if isRestart(txn[i].stmt) {
+				restart = i
+			}
+			continue
+		}
+		if errorCode(err) == retryErrorCode && restart >= 0 && retries < maxTxnRetries {
+			retries++
+			keepGoing, err := w.execute(statement{
+				production: "rollback to savepoint",
+				stmt:       "rollback to savepoint " + restartSavepoint,
+			})
+			if !keepGoing {
+				return false
+			}
+			if err == nil {
+				i = restart
+				continue
+			}
+		}
+		// The transaction may already be over, for instance if the server
+		// crashed, so there's nothing to learn from this failing.
+		_ = w.exec("rollback")
+		return true
+	}
+	return true
+}
+
+// maxExecutions is the maximum number of times a prepared statement is
+// executed.
+const maxExecutions = 10
+
+// stepPrepared generates a statement with placeholders, prepares it and
+// executes it several times with random arguments. It returns false if the
+// worker should stop.
+func (w *worker) stepPrepared() bool {
+	expr, typs, ok := w.smither.generatePrepared()
+	if !ok {
+		return true
+	}
+	st := w.smither.makeStatement(expr)
+	w.printf("-- prepare\n%s\n\n", st.stmt)
+
+	// If the server crashes, the worker reconnects and the statement is
+	// gone along with the old connection.
+	conn := w.conn
+	var prepared *sql.Stmt
+	prepare := st
+	prepare.prepare = true
+	keepGoing, err := w.do(prepare, func(ctx context.Context) (*sql.Rows, error) {
+		var err error
+		prepared, err = conn.PrepareContext(ctx, st.stmt)
+		return nil, err
+	})
+	if err != nil {
+		return keepGoing
+	}
+	defer prepared.Close()
+
+	for i := 1 + w.smither.rnd.Intn(maxExecutions); i > 0 && w.conn == conn; i-- {
+		st.args = placeholderArgs(w.smither.rnd, typs)
+		w.printf("-- execute (%s)\n\n", formatArgs(st.args))
+		keepGoing, _ := w.do(st, func(ctx context.Context) (*sql.Rows, error) {
+			return prepared.QueryContext(ctx, st.args...)
+		})
+		if !keepGoing {
+			return false
+		}
+	}
+	return true
+}
+
+// statement is a statement for a worker to execute.
+type statement struct {
+	// production is the name statistics about the statement are recorded
+	// under.
+	production string
+	stmt       string
+	// args are the arguments of a prepared statement.
+	args []interface{}
+	// prepare is set if the statement is only prepared, not executed.
+	prepare bool
+	// generated is set if the statement was generated or mutated, rather
+	// than controlling a transaction.
+	generated bool
+	// expr is the expression the statement was generated from, if it was
+	// generated, and overloads are the operator and function overloads it
+	// uses.
+	expr      relExpr
+	overloads []string
+	// ddl is set if the statement changes the schema, which is reloaded
+	// after it succeeds.
+	ddl bool
+	// populate is set if the statement populates a table. It isn't printed
+	// unless it's a finding, and its ordinary errors are expected, since
+	// many rows are rejected.
+	populate bool
+	// scalar is set if the statement is a scalar subquery, executed on its
+	// own, which should return at most one row.
+	scalar bool
+	// bug is set if the generated statement doesn't parse, in which case it
+	// isn't executed.
+	bug error
+}
+
+// makeStatement returns the statement for expr, which has just been
+// generated.
+func (s *Smither) makeStatement(expr relExpr) statement {
+	st := statement{
+		production: productionName(expr),
+		expr:       expr,
+		overloads:  usedOverloads(expr),
+		ddl:        isDDL(expr),
+		generated:  true,
+	}
+	s.coverage.emit(st.overloads)
+	st.stmt, st.bug = format(expr)
+	if st.bug != nil {
+		st.stmt = st.bug.(*GeneratorBugError).SQL
+	}
+	return st
+}
+
+// reportBug reports st, which doesn't round trip through the parser, as a
+// finding.
+func (w *worker) reportBug(st statement) {
+	w.printf("\nfinding: worker %d (seed %d): %v\n\n", w.id, w.seed, st.bug)
+	w.stats.record(st.production, generatorBugCode, errorInternal)
+}
+
+// reportViolations reports the checks the result of st failed as a finding.
+func (w *worker) reportViolations(st statement, violations []string) {
+	w.printf("\nfinding: worker %d (seed %d): result check: %s\n\n",
+		w.id, w.seed, strings.Join(violations, "; "))
+	w.stats.record(st.production, resultCheckCode, errorInternal)
+}
+
+// execute executes st and records the outcome. If the server crashed, it's
+// reported as a finding and, if the run keeps going, the worker reconnects.
+// It returns the error st failed with, if any, and false if the worker should
+// stop.
+func (w *worker) execute(st statement) (keepGoing bool, _ error) {
+	w.printf("%s\n\n", st.stmt)
+	return w.do(st, func(ctx context.Context) (*sql.Rows, error) {
+		return w.conn.QueryContext(ctx, st.stmt)
+	})
+}
+
+// do runs f, which executes st, and handles its outcome like execute. The
+// rows f returns, if any, are read and closed.
+func (w *worker) do(
+	st statement, f func(context.Context) (*sql.Rows, error),
+) (keepGoing bool, _ error) {
+	production := st.production
+	if st.bug != nil {
+		w.reportBug(st)
+		return true, st.bug
+	}
+	ctx, cancel := w.ctx, func() {}
+	if w.opts.StatementTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, w.opts.StatementTimeout)
+	}
+	start := time.Now()
+	rows, err := f(ctx)
+	e := execution{
+		stmt:          st.stmt,
+		args:          st.args,
+		prepare:       st.prepare,
+		generated:     st.generated,
+		deterministic: st.expr != nil && isDeterministic(st.expr),
+	}
+	var check *resultCheck
+	if err == nil && rows != nil {
+		if st.expr != nil {
+			check = makeResultCheck(st.expr, st.scalar)
+		}
+		err = readRows(rows, w.sinks.wantsRows(), check, &e)
+	}
+	e.duration = time.Since(start)
+	if err == nil {
+		cancel()
+		w.emit(e)
+		violations := check.finish()
+		for _, n := range check.noted() {
+			w.printf("-- note: %s\n\n", n)
+		}
+		if len(violations) > 0 {
+			w.reportViolations(st, violations)
+		} else {
+			w.stats.record(production, "", errorAllowed)
+		}
+		w.coverage.execute(st.overloads)
+		if st.ddl {
+			if err := w.schema.ReloadSchemas(); err != nil {
+				w.printf("error: %v\n", err)
+			}
+		}
+		return true, nil
+	}
+	if w.ctx.Err() != nil {
+		// The run was stopped by another worker.
+		cancel()
+		return false, err
+	}
+	status := w.hc.check(ctx, err)
+	cancel()
+
+	if st.populate && (status != healthy || w.allowed.classify(err) == errorInternal) {
+		w.printf("%s\n", st.stmt)
+	}
+	if status != healthy {
+		// TODO(justin): we should dump the schema we used along with the panicking query in this case.
+		w.printf("\nfinding: worker %d (seed %d): %s: %v\n\n", w.id, w.seed, status, err)
+		e.code = status.String()
+		w.emit(e)
+		w.stats.record(production, status.String(), errorInternal)
+		if status == hung {
+			return true, err
+		}
+		if !w.opts.KeepGoing {
+			w.stop()
+			return false, err
+		}
+		if err := w.hc.recover(); err != nil {
+			w.printf("error: %v\n", err)
+			w.stop()
+			return false, err
+		}
+		if err := w.connect(); err != nil {
+			w.printf("error: %v\n", err)
+			w.stop()
+			return false, err
+		}
+		return true, err
+	}
+
+	e.code = errorCode(err)
+	w.emit(e)
+	class := w.allowed.classify(err)
+	w.stats.record(production, errorCode(err), class)
+	switch class {
+	case errorInternal:
+		w.printf("\nfinding: worker %d (seed %d): %s: %v\n\n", w.id, w.seed, errorCode(err), err)
+	case errorExpected:
+		if !st.populate {
+			w.printf("\nerror: %v\n\n", err)
+		}
+	}
+	return true, err
+}
+
+// prefixWriter prefixes every line written to it. It is safe for concurrent
+// use, and can share its underlying writer with other prefixWriters through
+// mu.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		buf.Write(p.prefix)
+		buf.Write(line)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}